@@ -0,0 +1,684 @@
+package luajit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// PID identifies an actor of an ActorSystem.
+type PID uint64
+
+// ActorSystem runs actors: scripts each running in their own state and goroutine,
+// exchanging messages instead of sharing memory.
+//
+// The script of an actor runs in the main coroutine of its state, with these globals:
+//
+//	pid = self()                   -- The PID of the actor
+//	ok = send(pid, msg)            -- Sends a copy of msg, false if the actor doesn't exist or is done
+//	msg = receive(timeout)         -- Waits for a message, returning nil, "timeout" after timeout seconds if given
+//	pid = spawn(script, opts)      -- Starts an actor, opts being a table with the fields restarts and link
+//	ok = link(pid)                 -- Links the actor with pid, false if it doesn't exist or is done
+//
+// Messages are copied like Transfer with WithUserdata copies values: nil, booleans, numbers, strings,
+// tables with their metatables, including shared references and cycles, and userdata representing Go values.
+// Sending anything else, such as a function, fails.
+//
+// Scripts run with the JIT compiler off, so that Close can interrupt them, see CallContext.
+//
+// When an actor fails, the actors linked to it receive the message {exit = pid, reason = err, restarting = bool}.
+// An actor spawned with restarts is then started again with a new state, up to that many times,
+// keeping its PID, links and mailbox.
+//
+// An ActorSystem is safe for concurrent use.
+type ActorSystem struct {
+	setup func(s State) error
+
+	mu     sync.Mutex
+	actors map[PID]*Actor
+	last   PID
+	wg     sync.WaitGroup
+	stop   chan struct{}
+	once   sync.Once
+	ctx    context.Context // Interrupts the scripts once the system is closed
+	cancel context.CancelFunc
+}
+
+// SpawnOption configures an actor.
+type SpawnOption func(*Actor)
+
+// WithRestarts restarts the actor up to n times when it fails.
+func WithRestarts(n int) SpawnOption {
+	return func(a *Actor) {
+		a.restarts = n
+	}
+}
+
+// LinkedTo links the actor to the actors pids.
+func LinkedTo(pids ...PID) SpawnOption {
+	return func(a *Actor) {
+		a.linkTo = append(a.linkTo, pids...)
+	}
+}
+
+// NewActorSystem creates an actor system calling setup, which may be nil, on the state of each actor
+// once the standard libraries are open.
+func NewActorSystem(setup func(s State) error) *ActorSystem {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ActorSystem{
+		setup:  setup,
+		actors: make(map[PID]*Actor),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Spawn starts an actor running script.
+func (sys *ActorSystem) Spawn(script string, opts ...SpawnOption) (*Actor, error) {
+	a := &Actor{
+		sys:    sys,
+		script: script,
+		links:  make(map[PID]bool),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	sys.mu.Lock()
+	if sys.closed() {
+		sys.mu.Unlock()
+		return nil, errors.New("luajit: actor system closed")
+	}
+
+	sys.last++
+	a.pid = sys.last
+	sys.actors[a.pid] = a
+	sys.wg.Add(1)
+	sys.mu.Unlock()
+
+	for _, pid := range a.linkTo {
+		sys.Link(a.pid, pid)
+	}
+
+	go a.run()
+	return a, nil
+}
+
+// Actor returns the actor pid, or nil if it doesn't exist or is done.
+func (sys *ActorSystem) Actor(pid PID) *Actor {
+	sys.mu.Lock()
+	defer sys.mu.Unlock()
+	return sys.actors[pid]
+}
+
+// Send sends msg to the actor pid, see Actor.Send.
+func (sys *ActorSystem) Send(pid PID, msg any) bool {
+	a := sys.Actor(pid)
+	return a != nil && a.Send(msg)
+}
+
+// Link links the actors a and b, returning false if either doesn't exist or is done.
+func (sys *ActorSystem) Link(a, b PID) bool {
+	sys.mu.Lock()
+	defer sys.mu.Unlock()
+
+	aa, ab := sys.actors[a], sys.actors[b]
+	if aa == nil || ab == nil || a == b {
+		return false
+	}
+
+	aa.links[b] = true
+	ab.links[a] = true
+	return true
+}
+
+// Wait waits until every actor is done.
+func (sys *ActorSystem) Wait() {
+	sys.wg.Wait()
+}
+
+// Close stops the actors and waits for them.
+// Actors waiting for a message stop at once, and running scripts are interrupted.
+func (sys *ActorSystem) Close() {
+	sys.once.Do(func() {
+		close(sys.stop)
+		sys.cancel()
+	})
+	sys.wg.Wait()
+}
+
+// closed returns whether Close was called.
+func (sys *ActorSystem) closed() bool {
+	select {
+	case <-sys.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Actor is a script running in its own state and goroutine, see ActorSystem.
+type Actor struct {
+	sys      *ActorSystem
+	pid      PID
+	script   string
+	restarts int
+	linkTo   []PID
+	links    map[PID]bool // Guarded by the system's mutex
+
+	mu      sync.Mutex
+	mailbox []func(L lua.State) error // Each pushes a message
+	notify  chan struct{}
+	done    chan struct{}
+	err     error
+
+	// Used by the actor's goroutine only.
+	co        *Coroutine
+	receiving bool
+	timeout   time.Duration // How long to wait for a message, or a negative duration to wait forever
+}
+
+// PID returns the PID of the actor.
+func (a *Actor) PID() PID {
+	return a.pid
+}
+
+// Send queues msg in the mailbox of the actor, returning false if the actor is done.
+//
+// msg is converted as by Marshal once received, on the actor's goroutine, and must not be modified afterwards.
+func (a *Actor) Send(msg any) bool {
+	return a.post(func(L lua.State) error {
+		return pushValue(L, msg)
+	})
+}
+
+// Done returns a channel that's closed once the actor is done.
+func (a *Actor) Done() <-chan struct{} {
+	return a.done
+}
+
+// Err returns the error the actor failed with once done, or nil if it finished or was stopped.
+func (a *Actor) Err() error {
+	select {
+	case <-a.done:
+		return a.err
+	default:
+		return nil
+	}
+}
+
+// post queues a message pushed by push.
+func (a *Actor) post(push func(L lua.State) error) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	select {
+	case <-a.done:
+		return false
+	default:
+	}
+
+	a.mailbox = append(a.mailbox, push)
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// pop returns the oldest message, or nil if there is none.
+func (a *Actor) pop() func(L lua.State) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.mailbox) == 0 {
+		return nil
+	}
+
+	m := a.mailbox[0]
+	a.mailbox[0] = nil
+	a.mailbox = a.mailbox[1:]
+	return m
+}
+
+// run runs the actor until it's done, restarting it as configured.
+func (a *Actor) run() {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = a.runOnce()
+		if err == nil || a.sys.closed() {
+			// Actors interrupted by Close are stopped rather than failed.
+			err = nil
+			break
+		}
+
+		restarting := attempt < a.restarts
+		a.notifyLinks(err, restarting)
+		if !restarting {
+			break
+		}
+	}
+
+	a.sys.mu.Lock()
+	delete(a.sys.actors, a.pid)
+	for pid := range a.links {
+		if l := a.sys.actors[pid]; l != nil {
+			delete(l.links, a.pid)
+		}
+	}
+	a.sys.mu.Unlock()
+
+	a.mu.Lock()
+	a.err = err
+	a.mailbox = nil
+	close(a.done)
+	a.mu.Unlock()
+
+	a.sys.wg.Done()
+}
+
+// notifyLinks sends the failure of the actor to the actors linked to it.
+func (a *Actor) notifyLinks(err error, restarting bool) {
+	a.sys.mu.Lock()
+	linked := make([]*Actor, 0, len(a.links))
+	for pid := range a.links {
+		if l := a.sys.actors[pid]; l != nil {
+			linked = append(linked, l)
+		}
+	}
+	a.sys.mu.Unlock()
+
+	msg := map[string]any{"exit": a.pid, "reason": err.Error(), "restarting": restarting}
+	for _, l := range linked {
+		l.Send(msg)
+	}
+}
+
+// runOnce runs the script in a new state until it finishes, fails or the system is closed.
+func (a *Actor) runOnce() error {
+	s := NewState()
+	defer s.Close()
+
+	s.OpenLibs()
+	if a.sys.setup != nil {
+		if err := a.sys.setup(s); err != nil {
+			return err
+		}
+	}
+
+	if err := a.openPrimitives(s); err != nil {
+		return err
+	}
+
+	fn, err := s.LoadString(a.script, fmt.Sprintf("=actor %d", a.pid))
+	if err != nil {
+		return err
+	}
+
+	if a.co, err = s.NewCoroutine(fn); err != nil {
+		return err
+	}
+	defer a.co.Close()
+
+	return s.runLimited(&limiter{ctx: a.sys.ctx}, a.loop)
+}
+
+// loop runs the script, resuming it with the messages it receives.
+func (a *Actor) loop() error {
+	resume := func(L lua.State) (int, error) { return 0, nil }
+	for {
+		_, done, err := a.co.resume(resume)
+		if err != nil || done {
+			return err
+		}
+
+		resume = func(L lua.State) (int, error) { return 0, nil }
+		if !a.receiving {
+			// The script called coroutine.yield.
+			continue
+		}
+
+		a.receiving = false
+		m, ok := a.wait(a.timeout)
+		if !ok {
+			return nil
+		}
+
+		resume = func(L lua.State) (int, error) {
+			return pushResults(L, func(L lua.State) (int, error) {
+				return pushMessage(L, m)
+			}), nil
+		}
+	}
+}
+
+// wait waits for a message, returning nil once timeout elapses, or false if the system is closed.
+func (a *Actor) wait(timeout time.Duration) (func(L lua.State) error, bool) {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	for {
+		if m := a.pop(); m != nil {
+			return m, true
+		}
+
+		select {
+		case <-a.notify:
+		case <-expired:
+			return nil, true
+		case <-a.sys.stop:
+			return nil, false
+		}
+	}
+}
+
+// pushMessage pushes the message pushed by m, or nil and "timeout" if m is nil.
+func pushMessage(L lua.State, m func(L lua.State) error) (int, error) {
+	if m == nil {
+		lua.PushNil(L)
+		lua.PushString(L, "timeout")
+		return 2, nil
+	}
+
+	return 1, m(L)
+}
+
+// openPrimitives sets the Lua functions of the actor as globals of s.
+func (a *Actor) openPrimitives(s State) error {
+	primitives := map[string]callable{
+		"self":    a.luaSelf,
+		"send":    a.luaSend,
+		"receive": a.luaReceive,
+		"spawn":   a.luaSpawn,
+		"link":    a.luaLink,
+	}
+
+	L := s.thread()
+	for name, c := range primitives {
+		pushCallable(L, c)
+		if err := setGlobal(L, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Actor) luaSelf(L lua.State) (int, error) {
+	lua.PushNumber(L, lua.Number(a.pid))
+	return 1, nil
+}
+
+func (a *Actor) luaSend(L lua.State) (int, error) {
+	pid, err := checkPID(L, 1, "send")
+	if err != nil {
+		return 0, err
+	}
+
+	msg, err := detach(L, 2)
+	if err != nil {
+		return 0, &ArgError{Arg: 2, Func: "send", Err: err}
+	}
+
+	target := a.sys.Actor(pid)
+	ok := target != nil && target.post(func(L lua.State) error {
+		return attach(L, msg)
+	})
+
+	lua.PushBoolean(L, ok)
+	return 1, nil
+}
+
+func (a *Actor) luaReceive(L lua.State) (int, error) {
+	timeout := time.Duration(-1)
+	if !lua.IsNoneOrNil(L, 1) {
+		d, err := checkSeconds(L, 1, "receive")
+		if err != nil {
+			return 0, err
+		}
+		timeout = d
+	}
+
+	if a.co == nil || L != a.co.co {
+		return 0, errors.New("luajit: receive must be called from the main coroutine of the actor")
+	}
+
+	if m := a.pop(); m != nil || timeout == 0 {
+		return pushMessage(L, m)
+	}
+
+	a.receiving, a.timeout = true, timeout
+	return 0, errYield
+}
+
+// spawnOptions are the options of spawn in Lua.
+type spawnOptions struct {
+	Restarts int  `lua:"restarts"`
+	Link     bool `lua:"link"`
+}
+
+func (a *Actor) luaSpawn(L lua.State) (int, error) {
+	if lua.Type(L, 1) != lua.TString {
+		err := &TypeError{Expected: "string", Got: lua.TypeNameOf(L, 1)}
+		return 0, &ArgError{Arg: 1, Func: "spawn", Err: &UnmarshalError{Err: err}}
+	}
+
+	var opts spawnOptions
+	if !lua.IsNoneOrNil(L, 2) {
+		if err := Unmarshal(State(L), 2, &opts); err != nil {
+			return 0, &ArgError{Arg: 2, Func: "spawn", Err: err}
+		}
+	}
+
+	spawnOpts := []SpawnOption{WithRestarts(opts.Restarts)}
+	if opts.Link {
+		spawnOpts = append(spawnOpts, LinkedTo(a.pid))
+	}
+
+	child, err := a.sys.Spawn(string(lua.ToBytes(L, 1)), spawnOpts...)
+	if err != nil {
+		return 0, err
+	}
+
+	lua.PushNumber(L, lua.Number(child.pid))
+	return 1, nil
+}
+
+func (a *Actor) luaLink(L lua.State) (int, error) {
+	pid, err := checkPID(L, 1, "link")
+	if err != nil {
+		return 0, err
+	}
+
+	lua.PushBoolean(L, a.sys.Link(a.pid, pid))
+	return 1, nil
+}
+
+// checkPID returns the PID at idx.
+func checkPID(L lua.State, idx int, name string) (PID, error) {
+	if lua.Type(L, idx) != lua.TNumber {
+		err := &TypeError{Expected: "number", Got: lua.TypeNameOf(L, idx)}
+		return 0, &ArgError{Arg: idx, Func: name, Err: &UnmarshalError{Err: err}}
+	}
+
+	return PID(lua.ToNumber(L, idx)), nil
+}
+
+// A detached value is a Lua value copied out of a state, so that it can be pushed onto another one.
+// It's nil, a bool, a float64, a string, a detachedLight, a *detachedTable or a detachedHandle.
+type (
+	detachedLight uintptr
+
+	detachedTable struct {
+		keys, values []any
+		meta         *detachedTable
+	}
+
+	detachedHandle struct {
+		v    any
+		meta *metatable
+	}
+)
+
+// detach copies the value at idx out of its state.
+func detach(L lua.State, idx int) (any, error) {
+	if idx < 0 && idx > lua.RegistryIndex {
+		idx = lua.GetTop(L) + idx + 1
+	}
+
+	top := lua.GetTop(L)
+	defer lua.SetTop(L, top)
+	return detachValue(L, idx, make(map[uintptr]*detachedTable))
+}
+
+func detachValue(L lua.State, idx int, seen map[uintptr]*detachedTable) (any, error) {
+	if lua.CheckStack(L, 4) == 0 {
+		return nil, errTransferDepth
+	}
+
+	switch lua.Type(L, idx) {
+	case lua.TNone, lua.TNil:
+		return nil, nil
+	case lua.TBoolean:
+		return lua.ToBoolean(L, idx), nil
+	case lua.TNumber:
+		return float64(lua.ToNumber(L, idx)), nil
+	case lua.TString:
+		return string(lua.ToBytes(L, idx)), nil
+	case lua.TLightUserdata:
+		return detachedLight(lua.ToUserdata(L, idx)), nil
+	case lua.TUserdata:
+		if v, m := toHandleMeta(L, idx); m != nil {
+			return detachedHandle{v, m}, nil
+		}
+	case lua.TTable:
+		p := lua.ToPointer(L, idx)
+		if t, ok := seen[p]; ok {
+			return t, nil
+		}
+
+		t := &detachedTable{}
+		seen[p] = t
+
+		var err error
+		lua.ForEach(L, idx, func(k, v int) bool {
+			var dk, dv any
+			if dk, err = detachValue(L, k, seen); err != nil {
+				return false
+			}
+
+			if dv, err = detachValue(L, v, seen); err != nil {
+				return false
+			}
+
+			t.keys = append(t.keys, dk)
+			t.values = append(t.values, dv)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if lua.GetMetatable(L, idx) {
+			mt, err := detachValue(L, lua.GetTop(L), seen)
+			lua.Pop(L, 1)
+			if err != nil {
+				return nil, err
+			}
+
+			meta, ok := mt.(*detachedTable)
+			if !ok {
+				return nil, errors.New("luajit: cannot copy metatable")
+			}
+			t.meta = meta
+		}
+
+		return t, nil
+	}
+
+	return nil, fmt.Errorf("luajit: cannot copy %s", lua.TypeNameOf(L, idx))
+}
+
+// attach pushes a copy of the detached value v.
+func attach(L lua.State, v any) error {
+	lua.CheckStack(L, 2)
+	lua.NewTable(L)
+	cache := lua.GetTop(L)
+
+	err := attachValue(L, v, cache, make(map[*detachedTable]int))
+	if err != nil {
+		lua.SetTop(L, cache-1)
+		return err
+	}
+
+	lua.Remove(L, cache)
+	return nil
+}
+
+func attachValue(L lua.State, v any, cache int, ids map[*detachedTable]int) error {
+	if lua.CheckStack(L, 4) == 0 {
+		return errTransferDepth
+	}
+
+	switch v := v.(type) {
+	case nil:
+		lua.PushNil(L)
+	case bool:
+		lua.PushBoolean(L, v)
+	case float64:
+		lua.PushNumber(L, lua.Number(v))
+	case string:
+		lua.PushLString(L, v, len(v))
+	case detachedLight:
+		lua.PushLightUserdata(L, uintptr(v))
+	case detachedHandle:
+		pushHandleCopy(L, v.v, v.meta)
+	case *detachedTable:
+		if id, ok := ids[v]; ok {
+			lua.RawGetI(L, cache, id)
+			return nil
+		}
+
+		lua.CreateTable(L, 0, len(v.keys))
+		t := lua.GetTop(L)
+		id := len(ids) + 1
+		ids[v] = id
+		lua.PushValue(L, t)
+		lua.RawSetI(L, cache, id)
+
+		for i, k := range v.keys {
+			if err := attachValue(L, k, cache, ids); err != nil {
+				return err
+			}
+
+			if err := attachValue(L, v.values[i], cache, ids); err != nil {
+				return err
+			}
+
+			lua.RawSet(L, t)
+		}
+
+		if v.meta != nil {
+			if err := attachValue(L, v.meta, cache, ids); err != nil {
+				return err
+			}
+
+			lua.SetMetatable(L, t)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package luajit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// AsyncFunc is a Go function that runs outside of the state calling it.
+type AsyncFunc func(ctx context.Context, args []Value) ([]Value, error)
+
+// RegisterAsync sets the global name to a function calling fn in a new goroutine.
+//
+// When called from a coroutine run by the state's Scheduler, the coroutine is suspended until fn returns,
+// letting the others run meanwhile. ctx is done once the scheduler's Run returns or the scheduler is closed.
+// Elsewhere, fn is called directly with the context of the running call.
+//
+// The results of fn are returned to Lua, or its error raised.
+// As fn may run on another goroutine, it must only use args holding nil, booleans, numbers or strings:
+// the others cannot be used outside of the state.
+func (s State) RegisterAsync(name string, fn AsyncFunc) error {
+	L := s.thread()
+	pushCallable(L, func(L lua.State) (int, error) {
+		args := make([]Value, lua.GetTop(L))
+		for i := range args {
+			args[i] = toValue(L, i+1)
+		}
+
+		sc, t := taskOf(L)
+		if t == nil {
+			results, err := fn(State(L).data().context(), args)
+			if err != nil {
+				return 0, err
+			}
+
+			return len(results), pushResultValues(L, results)
+		}
+
+		sc.await(t, func(ctx context.Context) callable {
+			results, err := callAsync(ctx, fn, args)
+			return func(L lua.State) (int, error) {
+				if err != nil {
+					return 0, err
+				}
+
+				return len(results), pushResultValues(L, results)
+			}
+		})
+
+		return 0, errYield
+	})
+
+	return setGlobal(L, name)
+}
+
+// callAsync calls fn, converting panics to errors.
+func callAsync(ctx context.Context, fn AsyncFunc, args []Value) (results []Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			results, err = nil, fmt.Errorf("luajit: panic in Go function: %v", r)
+		}
+	}()
+
+	return fn(ctx, args)
+}
+
+// pushResultValues pushes each value, or nothing if an error is returned.
+func pushResultValues(L lua.State, vs []Value) error {
+	top := lua.GetTop(L)
+	lua.CheckStack(L, len(vs))
+	for _, v := range vs {
+		if err := v.push(L); err != nil {
+			lua.SetTop(L, top)
+			return err
+		}
+	}
+
+	return nil
+}
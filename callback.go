@@ -0,0 +1,370 @@
+package luajit
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// callable is the Go side of a function exposed to Lua.
+//
+// It receives its arguments on the stack of L and returns the number of results it pushed.
+type callable func(L lua.State) (int, error)
+
+var (
+	dispatchCallback lua.Callback
+	collectCallback  lua.Callback
+)
+
+func init() {
+	dispatchCallback = lua.NewCallback(dispatch)
+	collectCallback = lua.NewCallback(collect)
+}
+
+// handles maps userdata addresses to the Go values they represent.
+var handles struct {
+	sync.Mutex
+	m map[uintptr]any
+}
+
+// metatable describes the metatable shared by every userdata of a kind.
+type metatable struct {
+	name string            // The registry key of the metatable
+	init func(L lua.State) // Fills the metatable at the top of the stack, may be nil
+}
+
+var (
+	funcMeta  = &metatable{name: "go-luajit.func"}
+	errorMeta = &metatable{name: "go-luajit.error", init: initErrorMeta}
+)
+
+// metatables maps the names of the metatables created so far to them.
+var metatables sync.Map
+
+// push pushes the metatable onto the stack, creating it if needed.
+func (m *metatable) push(L lua.State) {
+	lua.GetField(L, lua.RegistryIndex, m.name)
+	if !lua.IsNil(L, -1) {
+		return
+	}
+
+	lua.Pop(L, 1)
+	lua.NewTable(L)
+
+	lua.PushCallback(L, collectCallback, 0)
+	lua.SetField(L, -2, "__gc")
+
+	lua.PushBoolean(L, false)
+	lua.SetField(L, -2, "__metatable")
+
+	lua.PushString(L, m.name)
+	lua.SetField(L, -2, "__name")
+	metatables.Store(m.name, m)
+
+	if m.init != nil {
+		m.init(L)
+	}
+
+	lua.PushValue(L, -1)
+	lua.SetField(L, lua.RegistryIndex, m.name)
+}
+
+// pushHandle pushes a new userdata representing v.
+//
+// v is kept alive until the userdata is collected.
+func pushHandle(L lua.State, v any, meta *metatable) {
+	p := lua.NewUserdata(L, 1)
+
+	handles.Lock()
+	if handles.m == nil {
+		handles.m = make(map[uintptr]any)
+	}
+	handles.m[p] = v
+	handles.Unlock()
+
+	meta.push(L)
+	lua.SetMetatable(L, -2)
+}
+
+// toHandle returns the Go value represented by the userdata at idx, or nil.
+func toHandle(L lua.State, idx int) any {
+	if lua.Type(L, idx) != lua.TUserdata {
+		return nil
+	}
+
+	handles.Lock()
+	defer handles.Unlock()
+	return handles.m[lua.ToUserdata(L, idx)]
+}
+
+// collect is the __gc metamethod of every handle.
+func collect(L lua.State) int32 {
+	p := lua.ToUserdata(L, 1)
+
+	handles.Lock()
+	delete(handles.m, p)
+	handles.Unlock()
+	return 0
+}
+
+// errYield is returned by callables to yield the values they pushed, rather than return them.
+//
+// The coroutine must then be resumed with the results of the callable, as returned by dispatch.
+var errYield = errors.New("luajit: yield")
+
+// dispatch calls the callable stored in its first upvalue.
+//
+// Results are prefixed with true on success.
+// On failure, false, the error value and the level to raise it at are returned instead.
+// To yield, the values to yield are prefixed with nil.
+func dispatch(L lua.State) int32 {
+	fn, _ := toHandle(L, lua.UpvalueIndex(1)).(callable)
+	if fn == nil {
+		lua.PushBoolean(L, false)
+		lua.PushString(L, "luajit: invalid Go function")
+		return 2
+	}
+
+	d := State(L).data()
+	leave := d.enter(L)
+	n, err := safeCall(L, fn)
+	leave()
+
+	// Interrupt the call as soon as possible, rather than at the next check of its limits.
+	if err == nil && d.lim != nil {
+		err = d.lim.interrupted()
+	}
+
+	switch {
+	case err == errYield:
+		lua.PushNil(L)
+	case err != nil:
+		pushFailure(L, err)
+		return 3
+	default:
+		lua.PushBoolean(L, true)
+	}
+
+	lua.Insert(L, -n-1)
+	return int32(n + 1)
+}
+
+// pushFailure pushes the results of a callable failing with err: false, the error value and its level.
+func pushFailure(L lua.State, err error) {
+	lua.PushBoolean(L, false)
+	pushError(L, err)
+	lua.PushInteger(L, 2)
+}
+
+// safeCall calls fn, converting panics to errors.
+func safeCall(L lua.State, fn callable) (n int, err error) {
+	top := lua.GetTop(L)
+	defer func() {
+		if r := recover(); r != nil {
+			lua.SetTop(L, top)
+			n, err = 0, fmt.Errorf("luajit: panic in Go function: %v", r)
+		}
+	}()
+
+	return fn(L)
+}
+
+// pushCallable pushes fn as a Lua function.
+func pushCallable(L lua.State, fn callable) {
+	pushHelper(L, "wrap")
+	pushDispatch(L, fn)
+	lua.Call(L, 1, 1)
+}
+
+// pushDispatch pushes fn as a Lua function that doesn't raise errors itself (see dispatch).
+func pushDispatch(L lua.State, fn callable) {
+	pushHandle(L, fn, funcMeta)
+	lua.PushCallback(L, dispatchCallback, 1)
+}
+
+// pushFunc pushes the Go function fn as a Lua function.
+//
+// 'name' is used in error messages.
+func pushFunc(L lua.State, name string, fn any) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("luajit: %q is not a function (%T)", name, fn)
+	}
+
+	c, err := reflectCallable(name, v, nil, false)
+	if err != nil {
+		return err
+	}
+
+	pushCallable(L, c)
+	return nil
+}
+
+// receiver is a kind of userdata representing Go pointers, to which methods can be bound.
+type receiver interface {
+	// pointerType returns the type of the pointers.
+	pointerType() reflect.Type
+
+	// self returns the pointer represented by the value at idx.
+	self(L lua.State, idx int) (reflect.Value, bool)
+
+	// push pushes the pointer v.
+	push(L lua.State, v reflect.Value)
+}
+
+// reflectCallable creates a callable that converts arguments and results of fn.
+//
+// If recv is not nil, results of its type, or pointers to it, are pushed as its userdata.
+// If method is also true, fn is a method expression and its receiver is taken from the first argument.
+// If the first parameter, after the receiver, is a context.Context, it receives the context of the running call.
+func reflectCallable(name string, fn reflect.Value, recv receiver, method bool) (callable, error) {
+	var self reflect.Type
+	if recv != nil {
+		self = recv.pointerType()
+	}
+
+	t := fn.Type()
+
+	ctxIn := -1
+	if first := btoi(method); t.NumIn() > first && t.In(first) == contextType {
+		ctxIn = first
+	}
+
+	for i := range t.NumIn() {
+		if (method && i == 0) || i == ctxIn {
+			continue
+		}
+
+		if !canConvert(t.In(i)) {
+			return nil, &UnsupportedTypeError{Type: t.In(i)}
+		}
+	}
+
+	nout := t.NumOut()
+	returnsErr := nout > 0 && t.Out(nout-1) == errorType
+	if returnsErr {
+		nout--
+	}
+
+	for i := range nout {
+		if self != nil && (t.Out(i) == self || t.Out(i) == self.Elem()) {
+			continue
+		}
+
+		if !canConvert(t.Out(i)) {
+			return nil, &UnsupportedTypeError{Type: t.Out(i)}
+		}
+	}
+
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	}
+
+	return func(L lua.State) (int, error) {
+		nargs := lua.GetTop(L)
+
+		in := make([]reflect.Value, 0, max(nargs, fixed))
+		arg := 1 // The next Lua argument
+		for i := range fixed {
+			switch {
+			case method && i == 0:
+				v, ok := recv.self(L, 1)
+				if !ok || !v.Type().AssignableTo(t.In(0)) {
+					err := &TypeError{Expected: t.In(0).String(), Got: lua.TypeNameOf(L, 1)}
+					return 0, &ArgError{Arg: 1, Func: name, Err: &UnmarshalError{Err: err}}
+				}
+
+				in = append(in, v)
+				arg++
+				continue
+			case i == ctxIn:
+				in = append(in, reflect.ValueOf(State(L).data().context()))
+				continue
+			}
+
+			v := reflect.New(t.In(i)).Elem()
+			if err := decode(L, arg, v); err != nil {
+				return 0, &ArgError{Arg: arg, Func: name, Err: err}
+			}
+
+			in = append(in, v)
+			arg++
+		}
+
+		if t.IsVariadic() {
+			elem := t.In(fixed).Elem()
+			for ; arg <= nargs; arg++ {
+				v := reflect.New(elem).Elem()
+				if err := decode(L, arg, v); err != nil {
+					return 0, &ArgError{Arg: arg, Func: name, Err: err}
+				}
+
+				in = append(in, v)
+			}
+		}
+
+		out := fn.Call(in)
+		if returnsErr {
+			if err := out[nout]; !err.IsNil() {
+				return 0, err.Interface().(error)
+			}
+
+			out = out[:nout]
+		}
+
+		top := lua.GetTop(L)
+		lua.CheckStack(L, len(out))
+		for _, v := range out {
+			if self != nil && (v.Type() == self || v.Type() == self.Elem()) {
+				pushSelf(L, recv, v)
+				continue
+			}
+
+			if err := encode(L, v); err != nil {
+				lua.SetTop(L, top)
+				return 0, err
+			}
+		}
+
+		return len(out), nil
+	}, nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// pushSelf pushes v, a value or pointer of the receiver's type, as its userdata.
+func pushSelf(L lua.State, recv receiver, v reflect.Value) {
+	switch {
+	case v.Kind() != reflect.Pointer:
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		recv.push(L, p)
+	case v.IsNil():
+		lua.PushNil(L)
+	default:
+		recv.push(L, v)
+	}
+}
+
+func initErrorMeta(L lua.State) {
+	pushCallable(L, func(L lua.State) (int, error) {
+		err, _ := toHandle(L, 1).(error)
+		if err == nil {
+			return 0, nil
+		}
+
+		lua.PushString(L, err.Error())
+		return 1, nil
+	})
+	lua.SetField(L, -2, "__tostring")
+}
@@ -0,0 +1,195 @@
+package luajit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// chanMeta is the metatable of Go channels, see Scheduler for their methods.
+var chanMeta = &metatable{name: "go-luajit.chan"}
+
+func init() {
+	chanMeta.init = initChanMeta
+}
+
+var chanMethods = map[string]callable{
+	"send":     chanSend,
+	"recv":     chanRecv,
+	"try_recv": chanTryRecv,
+	"close":    chanClose,
+	"select":   chanSelect,
+}
+
+func initChanMeta(L lua.State) {
+	lua.CreateTable(L, 0, len(chanMethods))
+	for name, c := range chanMethods {
+		pushCallable(L, c)
+		lua.SetField(L, -2, name)
+	}
+	lua.SetField(L, -2, "__index")
+
+	pushCallable(L, func(L lua.State) (int, error) {
+		ch := reflect.ValueOf(toHandle(L, 1))
+		lua.PushString(L, fmt.Sprintf("%s: %#x", ch.Type(), ch.Pointer()))
+		return 1, nil
+	})
+	lua.SetField(L, -2, "__tostring")
+}
+
+// checkChan returns the channel at idx, failing if it isn't one or doesn't allow dir.
+func checkChan(L lua.State, idx int, name string, dir reflect.ChanDir) (reflect.Value, error) {
+	ch := reflect.ValueOf(toHandle(L, idx))
+	if !ch.IsValid() || ch.Kind() != reflect.Chan {
+		err := &TypeError{Expected: "channel", Got: lua.TypeNameOf(L, idx)}
+		return reflect.Value{}, &ArgError{Arg: idx, Func: name, Err: &UnmarshalError{Err: err}}
+	}
+
+	if ch.Type().ChanDir()&dir == 0 {
+		return reflect.Value{}, &ArgError{Arg: idx, Func: name, Err: fmt.Errorf("cannot %s %s", name, ch.Type())}
+	}
+
+	return ch, nil
+}
+
+func chanSend(L lua.State) (int, error) {
+	ch, err := checkChan(L, 1, "send", reflect.SendDir)
+	if err != nil {
+		return 0, err
+	}
+
+	v := reflect.New(ch.Type().Elem()).Elem()
+	if err := decode(L, 2, v); err != nil {
+		return 0, &ArgError{Arg: 2, Func: "send", Err: err}
+	}
+
+	cases := []reflect.SelectCase{{Dir: reflect.SelectSend, Chan: ch, Send: v}}
+	return block(L, cases, func(L lua.State, _ int, _ reflect.Value, _ bool) (int, error) {
+		return 0, nil
+	})
+}
+
+func chanRecv(L lua.State) (int, error) {
+	ch, err := checkChan(L, 1, "recv", reflect.RecvDir)
+	if err != nil {
+		return 0, err
+	}
+
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: ch}}
+	return block(L, cases, func(L lua.State, _ int, v reflect.Value, ok bool) (int, error) {
+		return pushReceived(L, v, ok)
+	})
+}
+
+func chanTryRecv(L lua.State) (int, error) {
+	ch, err := checkChan(L, 1, "try_recv", reflect.RecvDir)
+	if err != nil {
+		return 0, err
+	}
+
+	chosen, v, ok := reflect.Select([]reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectDefault},
+	})
+	if chosen == 1 {
+		lua.PushNil(L)
+		lua.PushNil(L)
+		return 2, nil
+	}
+
+	return pushReceived(L, v, ok)
+}
+
+func chanClose(L lua.State) (int, error) {
+	ch, err := checkChan(L, 1, "close", reflect.SendDir)
+	if err != nil {
+		return 0, err
+	}
+
+	ch.Close()
+	return 0, nil
+}
+
+func chanSelect(L lua.State) (int, error) {
+	cases := make([]reflect.SelectCase, max(lua.GetTop(L), 1))
+	for i := range cases {
+		ch, err := checkChan(L, i+1, "select", reflect.RecvDir)
+		if err != nil {
+			return 0, err
+		}
+
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: ch}
+	}
+
+	return block(L, cases, func(L lua.State, chosen int, v reflect.Value, ok bool) (int, error) {
+		lua.PushInteger(L, lua.Integer(chosen+1))
+		n, err := pushReceived(L, v, ok)
+		if err != nil {
+			lua.Pop(L, 1)
+			return 0, err
+		}
+
+		return n + 1, nil
+	})
+}
+
+// pushReceived pushes a received value and whether the channel was open.
+func pushReceived(L lua.State, v reflect.Value, ok bool) (int, error) {
+	if !ok {
+		lua.PushNil(L)
+	} else if err := encode(L, v); err != nil {
+		return 0, err
+	}
+
+	lua.PushBoolean(L, ok)
+	return 2, nil
+}
+
+// block performs a select over cases, and pushes its outcome with results.
+//
+// If L is a coroutine run by a scheduler, it's suspended until the select completes.
+// Otherwise, the select blocks until it completes or the context of the running call is done.
+func block(L lua.State, cases []reflect.SelectCase, results func(L lua.State, chosen int, v reflect.Value, ok bool) (int, error)) (int, error) {
+	if sc, t := taskOf(L); t != nil {
+		sc.await(t, func(ctx context.Context) callable {
+			chosen, v, ok, err := selectUntil(cases, ctx.Done(), func() error { return sc.cause(ctx) })
+			return func(L lua.State) (int, error) {
+				if err != nil {
+					return 0, err
+				}
+
+				return results(L, chosen, v, ok)
+			}
+		})
+
+		return 0, errYield
+	}
+
+	ctx := State(L).data().context()
+	chosen, v, ok, err := selectUntil(cases, ctx.Done(), ctx.Err)
+	if err != nil {
+		return 0, err
+	}
+
+	return results(L, chosen, v, ok)
+}
+
+// selectUntil performs a select over cases, failing with the error returned by cause once done is closed.
+func selectUntil(cases []reflect.SelectCase, done <-chan struct{}, cause func() error) (chosen int, v reflect.Value, ok bool, err error) {
+	defer func() {
+		// Sending on or closing a closed channel panics.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("luajit: %v", r)
+		}
+	}()
+
+	cases = append(cases[:len(cases):len(cases)], reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	chosen, v, ok = reflect.Select(cases)
+	if chosen == len(cases)-1 {
+		return 0, reflect.Value{}, false, cause()
+	}
+
+	return chosen, v, ok, nil
+}
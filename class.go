@@ -0,0 +1,526 @@
+package luajit
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// Class builds a Lua class whose instances are userdata representing *T.
+//
+// Unlike objects (see State.PushObject), instances only expose what the class declares:
+//
+//	luajit.NewClass[Vec2]("Vec2").
+//		Constructor(func(x, y float64) *Vec2 { return &Vec2{x, y} }).
+//		Property("x", func(v *Vec2) float64 { return v.X }, func(v *Vec2, x float64) { v.X = x }).
+//		Method("len", (*Vec2).Len).
+//		Method("__add", (*Vec2).Add).
+//		Register(s)
+//
+// Lua then creates instances with Vec2.new(1, 2), or Vec2(1, 2), and checks them with Vec2.is(v).
+//
+// Errors in the declarations are reported by Register and RegisterIn.
+// A class must not be modified once it's registered.
+type Class[T any] struct {
+	ci *classInfo
+}
+
+// AnyClass is implemented by every *Class[T], so that classes of different types can extend each other.
+type AnyClass interface {
+	class() *classInfo
+}
+
+// classInfo is the untyped side of a Class.
+type classInfo struct {
+	name   string
+	typ    reflect.Type // *T
+	meta   *metatable
+	parent *classInfo
+	err    error // The first error in the declarations
+
+	ctor        callable
+	methods     map[string]callable
+	metamethods map[string]callable
+	props       map[string]property
+	statics     map[string]callable
+}
+
+// property is the getter and setter of a property; set is nil for read-only properties.
+type property struct {
+	get, set callable
+}
+
+var classCount atomic.Int64
+
+// NewClass starts the declaration of a class named name.
+//
+// Until a constructor is declared, instances are created from a table of fields (see Unmarshal),
+// or the zero T when called without arguments.
+func NewClass[T any](name string) *Class[T] {
+	ci := &classInfo{
+		name:        name,
+		typ:         reflect.TypeFor[*T](),
+		methods:     make(map[string]callable),
+		metamethods: make(map[string]callable),
+		props:       make(map[string]property),
+		statics:     make(map[string]callable),
+	}
+	ci.meta = &metatable{
+		name: fmt.Sprintf("go-luajit.class.%d", classCount.Add(1)),
+		init: ci.initMeta,
+	}
+	ci.ctor = ci.newZero
+
+	return &Class[T]{ci: ci}
+}
+
+func (c *Class[T]) class() *classInfo {
+	return c.ci
+}
+
+// Constructor sets the function creating instances.
+//
+// It can have any parameters, and must return T or *T, optionally followed by an error.
+func (c *Class[T]) Constructor(fn any) *Class[T] {
+	ci := c.ci
+	v, err := ci.checkFunc("new", fn)
+	if err == nil {
+		t := v.Type()
+		if t.NumOut() == 0 || (t.Out(0) != ci.typ && t.Out(0) != ci.typ.Elem()) {
+			err = fmt.Errorf("must return %s", ci.typ)
+		}
+	}
+	if err == nil {
+		ci.ctor, err = reflectCallable(ci.name+".new", v, ci, false)
+	}
+
+	ci.fail("new", err)
+	return c
+}
+
+// Method adds a method called with Lua's method syntax (obj:name(...)).
+//
+// fn takes the instance as its first parameter, such as a method expression like (*T).Len.
+// Methods named after a metamethod, like "__add" or "__tostring", implement it instead.
+// Only the metamethods that MapMetamethod accepts can be implemented, as the others are used by classes,
+// and binary operators receive the instance as described by MapMetamethod.
+func (c *Class[T]) Method(name string, fn any) *Class[T] {
+	ci := c.ci
+	var m callable
+	var err error
+	if strings.HasPrefix(name, "__") && !metamethodEvents[name] {
+		err = errors.New("not a metamethod that can be implemented")
+	} else {
+		m, err = ci.method(name, fn)
+	}
+	if err == nil {
+		if strings.HasPrefix(name, "__") {
+			ci.metamethods[name] = binaryMetamethod(name, m, ci, reflect.TypeOf(fn))
+		} else {
+			ci.methods[name] = m
+		}
+	}
+
+	ci.fail(name, err)
+	return c
+}
+
+// Property adds a field-like property, read through get and assigned through set.
+//
+// get has the form func(*T) V and set func(*T, V), both optionally returning an error.
+// If set is nil, the property is read-only.
+func (c *Class[T]) Property(name string, get, set any) *Class[T] {
+	ci := c.ci
+	var p property
+	var err error
+	if p.get, err = ci.method(name, get); err == nil && set != nil {
+		p.set, err = ci.method(name, set)
+	}
+	if err == nil {
+		ci.props[name] = p
+	}
+
+	ci.fail(name, err)
+	return c
+}
+
+// StaticMethod adds a function to the class table, called as Class.name(...).
+//
+// Results of type T or *T are returned as instances.
+func (c *Class[T]) StaticMethod(name string, fn any) *Class[T] {
+	ci := c.ci
+	v, err := ci.checkFunc(name, fn)
+	if err == nil {
+		ci.statics[name], err = reflectCallable(ci.name+"."+name, v, ci, false)
+	}
+
+	ci.fail(name, err)
+	return c
+}
+
+// Extends makes the class inherit the methods, properties and static methods of parent.
+//
+// T must embed the parent's type, or a pointer to it,
+// so that the parent's methods can be called on instances of the class.
+// Instances of the class are also instances of parent.
+func (c *Class[T]) Extends(parent AnyClass) *Class[T] {
+	ci, p := c.ci, parent.class()
+
+	var err error
+	switch {
+	case ci.parent != nil:
+		err = fmt.Errorf("already extends %s", ci.parent.name)
+	case !embeds(ci.typ.Elem(), p.typ.Elem()):
+		err = fmt.Errorf("%s does not embed %s", ci.typ.Elem(), p.typ.Elem())
+	default:
+		for a := p; a != nil; a = a.parent {
+			if a == ci {
+				err = fmt.Errorf("%s extends it", p.name)
+			}
+		}
+	}
+	if err == nil {
+		ci.parent = p
+	}
+
+	ci.fail("extends", err)
+	return c
+}
+
+// Register sets the global named after the class to its class table.
+func (c *Class[T]) Register(s State) error {
+	if c.ci.err != nil {
+		return c.ci.err
+	}
+
+	L := s.thread()
+	c.ci.pushClass(L)
+	return setGlobal(L, c.ci.name)
+}
+
+// RegisterIn sets the field of t named after the class to its class table, such as in a module.
+func (c *Class[T]) RegisterIn(t *Table) error {
+	if c.ci.err != nil {
+		return c.ci.err
+	}
+
+	L := t.ref.thread()
+	t.ref.push(L)
+	c.ci.pushClass(L)
+	lua.SetField(L, -2, c.ci.name)
+	lua.Pop(L, 1)
+	return nil
+}
+
+// fail records err, if it's the first error in the declarations.
+func (ci *classInfo) fail(name string, err error) {
+	if err != nil && ci.err == nil {
+		ci.err = fmt.Errorf("luajit: class %s: %s: %w", ci.name, name, err)
+	}
+}
+
+// checkFunc returns fn, failing if it isn't a function.
+func (ci *classInfo) checkFunc(name string, fn any) (reflect.Value, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return reflect.Value{}, fmt.Errorf("%T is not a function", fn)
+	}
+
+	return v, nil
+}
+
+// method creates the callable of a function taking an instance as its first parameter.
+func (ci *classInfo) method(name string, fn any) (callable, error) {
+	v, err := ci.checkFunc(name, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := v.Type(); t.NumIn() == 0 || t.In(0) != ci.typ {
+		return nil, fmt.Errorf("first parameter must be %s", ci.typ)
+	}
+
+	return reflectCallable(name, v, ci, true)
+}
+
+func (ci *classInfo) pointerType() reflect.Type {
+	return ci.typ
+}
+
+// self returns the instance at idx, which may be an instance of a class extending ci.
+func (ci *classInfo) self(L lua.State, idx int) (reflect.Value, bool) {
+	v := reflect.ValueOf(toHandle(L, idx))
+	if !v.IsValid() || v.Kind() != reflect.Pointer {
+		return reflect.Value{}, false
+	}
+
+	return upcast(v, ci.typ)
+}
+
+func (ci *classInfo) push(L lua.State, v reflect.Value) {
+	pushHandle(L, v.Interface(), ci.meta)
+}
+
+// pushClass pushes the class table.
+func (ci *classInfo) pushClass(L lua.State) {
+	ci.meta.push(L)
+	lua.GetField(L, -1, "__class")
+	lua.Replace(L, -2)
+}
+
+// property returns the property name of ci or its ancestors.
+func (ci *classInfo) property(name string) (property, bool) {
+	for c := ci; c != nil; c = c.parent {
+		if p, ok := c.props[name]; ok {
+			return p, true
+		}
+	}
+
+	return property{}, false
+}
+
+func (ci *classInfo) initMeta(L lua.State) {
+	lua.CheckStack(L, 4)
+
+	// Methods are looked up in the methods of the parent when missing.
+	lua.CreateTable(L, 0, len(ci.methods))
+	for name, c := range ci.methods {
+		pushCallable(L, c)
+		lua.SetField(L, -2, name)
+	}
+	if ci.parent != nil {
+		lua.CreateTable(L, 0, 1)
+		ci.parent.meta.push(L)
+		lua.GetField(L, -1, "__methods")
+		lua.Replace(L, -2)
+		lua.SetField(L, -2, "__index")
+		lua.SetMetatable(L, -2)
+	}
+	lua.SetField(L, -2, "__methods")
+
+	if ci.parent != nil {
+		ci.parent.meta.push(L)
+		lua.SetField(L, -2, "__parent")
+	}
+
+	pushCallable(L, ci.index)
+	lua.SetField(L, -2, "__index")
+
+	pushCallable(L, ci.newIndex)
+	lua.SetField(L, -2, "__newindex")
+
+	pushCallable(L, ci.toString)
+	lua.SetField(L, -2, "__tostring")
+
+	pushCallable(L, ci.equal)
+	lua.SetField(L, -2, "__eq")
+
+	// Metamethods are inherited unless overridden.
+	set := make(map[string]bool)
+	for c := ci; c != nil; c = c.parent {
+		for event, m := range c.metamethods {
+			if !set[event] {
+				set[event] = true
+				pushCallable(L, m)
+				lua.SetField(L, -2, event)
+			}
+		}
+	}
+
+	ci.initClass(L)
+	lua.SetField(L, -2, "__class")
+}
+
+// initClass pushes a new class table.
+func (ci *classInfo) initClass(L lua.State) {
+	lua.CreateTable(L, 0, len(ci.statics)+2)
+
+	pushCallable(L, ci.ctor)
+	lua.SetField(L, -2, "new")
+
+	pushCallable(L, ci.is)
+	lua.SetField(L, -2, "is")
+
+	for name, c := range ci.statics {
+		pushCallable(L, c)
+		lua.SetField(L, -2, name)
+	}
+
+	// Calling the class table creates an instance, and static methods are inherited.
+	lua.CreateTable(L, 0, 2)
+	pushCallable(L, ci.call)
+	lua.SetField(L, -2, "__call")
+	if ci.parent != nil {
+		ci.parent.pushClass(L)
+		lua.SetField(L, -2, "__index")
+	}
+	lua.SetMetatable(L, -2)
+}
+
+// newZero is the default constructor.
+func (ci *classInfo) newZero(L lua.State) (int, error) {
+	p := reflect.New(ci.typ.Elem())
+	if !lua.IsNoneOrNil(L, 1) {
+		if err := decode(L, 1, p.Elem()); err != nil {
+			return 0, &ArgError{Arg: 1, Func: ci.name + ".new", Err: err}
+		}
+	}
+
+	ci.push(L, p)
+	return 1, nil
+}
+
+// call is the __call metamethod of class tables.
+func (ci *classInfo) call(L lua.State) (int, error) {
+	lua.Remove(L, 1)
+	return ci.ctor(L)
+}
+
+// is returns if its argument is an instance of the class, or of a class extending it.
+func (ci *classInfo) is(L lua.State) (int, error) {
+	ok := false
+	if lua.GetMetatable(L, 1) { // mt
+		ci.meta.push(L) // mt class
+		for !ok && lua.IsTable(L, -2) {
+			ok = lua.RawEqual(L, -1, -2)
+			lua.PushString(L, "__parent") // mt class "__parent"
+			lua.RawGet(L, -3)             // mt class parent
+			lua.Replace(L, -3)            // parent class
+		}
+	}
+
+	lua.PushBoolean(L, ok)
+	return 1, nil
+}
+
+// index is the __index metamethod of instances.
+func (ci *classInfo) index(L lua.State) (int, error) {
+	if lua.Type(L, 2) == lua.TString {
+		if p, ok := ci.property(lua.ToString(L, 2)); ok {
+			lua.SetTop(L, 1)
+			return p.get(L)
+		}
+	}
+
+	lua.GetMetatable(L, 1)
+	lua.GetField(L, -1, "__methods")
+	lua.PushValue(L, 2)
+	lua.GetTable(L, -2)
+	return 1, nil
+}
+
+// newIndex is the __newindex metamethod of instances.
+func (ci *classInfo) newIndex(L lua.State) (int, error) {
+	key := lua.ToString(L, 2)
+	p, ok := ci.property(key)
+	if lua.Type(L, 2) != lua.TString || !ok {
+		return 0, &fieldError{Type: ci.typ.Elem(), Field: key, Err: errNoField}
+	}
+
+	if p.set == nil {
+		return 0, &fieldError{Type: ci.typ.Elem(), Field: key, Err: errReadOnly}
+	}
+
+	lua.Remove(L, 2)
+	lua.SetTop(L, 2)
+	_, err := p.set(L)
+	return 0, err
+}
+
+// toString is the default __tostring metamethod of instances.
+func (ci *classInfo) toString(L lua.State) (int, error) {
+	lua.PushString(L, fmt.Sprintf("%s: %p", ci.name, toHandle(L, 1)))
+	return 1, nil
+}
+
+// equal is the default __eq metamethod of instances.
+func (ci *classInfo) equal(L lua.State) (int, error) {
+	lua.PushBoolean(L, toHandle(L, 1) == toHandle(L, 2))
+	return 1, nil
+}
+
+// embeds returns if the struct type t embeds e, or a pointer to it.
+func embeds(t, e reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if f.Anonymous && (f.Type == e || f.Type == reflect.PointerTo(e)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var embedPaths sync.Map // map[[2]reflect.Type][]int
+
+// upcast converts the pointer v to the pointer type t, through embedded fields.
+func upcast(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if v.Type() == t {
+		return v, true
+	}
+
+	key := [2]reflect.Type{v.Type(), t}
+	cached, ok := embedPaths.Load(key)
+	if !ok {
+		cached, _ = embedPaths.LoadOrStore(key, embedPath(v.Type().Elem(), t.Elem(), nil))
+	}
+
+	path := cached.([]int)
+	if path == nil || v.IsNil() {
+		return reflect.Value{}, false
+	}
+
+	for _, i := range path {
+		v = v.Elem().Field(i)
+		if v.Kind() != reflect.Pointer {
+			v = v.Addr()
+		} else if v.IsNil() {
+			return reflect.Value{}, false
+		}
+	}
+
+	return v, true
+}
+
+// embedPath returns the indices of the embedded fields leading from the struct type t to e, or nil.
+func embedPath(t, e reflect.Type, seen map[reflect.Type]bool) []int {
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+
+	if seen == nil {
+		seen = make(map[reflect.Type]bool)
+	}
+	seen[t] = true
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+
+		if ft == e {
+			return []int{i}
+		}
+
+		if path := embedPath(ft, e, seen); path != nil {
+			return append([]int{i}, path...)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package luajit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewFakeClock(start)
+	a := c.After(time.Second)
+	b := c.After(2 * time.Second)
+
+	c.Advance(time.Second)
+	select {
+	case got := <-a:
+		if want := start.Add(time.Second); !got.Equal(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("channel due after 1s not notified")
+	}
+
+	select {
+	case <-b:
+		t.Fatal("channel due after 2s notified after 1s")
+	default:
+	}
+
+	// Channels no longer waited for are forgotten.
+	c.stop(b)
+	if n := len(c.waiters); n != 0 {
+		t.Errorf("got %d waiters, want 0", n)
+	}
+}
@@ -0,0 +1,151 @@
+package luajit
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CollapsedOption configures StartCollapsedProfile.
+type CollapsedOption func(*CollapsedProfiler)
+
+// WithVMStates splits the stacks by the VM state they were sampled in,
+// adding a root frame naming it, such as "[compiled]" or "[GC]".
+func WithVMStates() CollapsedOption {
+	return func(p *CollapsedProfiler) {
+		p.vmstates = true
+	}
+}
+
+// WithGoFrames folds the frames of the Go functions called from Lua into a single "[go]" frame.
+// Lua functions called back from Go are kept.
+func WithGoFrames() CollapsedOption {
+	return func(p *CollapsedProfiler) {
+		p.goFrames = true
+	}
+}
+
+// WithChunkFilter keeps the frames of the chunks for which fn returns true, dropping the others.
+// Frames of C and Go functions, which don't belong to a chunk, are kept.
+// Samples left without frames are dropped.
+func WithChunkFilter(fn func(chunk string) bool) CollapsedOption {
+	return func(p *CollapsedProfiler) {
+		p.filter = fn
+	}
+}
+
+// CollapsedProfiler collects the samples of LuaJIT's profiler as collapsed stacks,
+// see StartCollapsedProfile.
+type CollapsedProfiler struct {
+	p *Profiler
+	w io.Writer
+
+	vmstates bool
+	goFrames bool
+	filter   func(chunk string) bool
+}
+
+// collapsedStackFormat dumps every frame as "module:function;", outermost frame first.
+const collapsedStackFormat = "pF;"
+
+// StartCollapsedProfile starts LuaJIT's profiler like StartProfile.
+//
+// Once CollapsedProfiler.Stop is called, the samples are written to w in the collapsed format
+// read by flame graph tools, a line per stack:
+//
+//	main.lua:main;main.lua:update;main.lua:physics 42
+func StartCollapsedProfile(s State, w io.Writer, opts ProfileOptions, copts ...CollapsedOption) (*CollapsedProfiler, error) {
+	c := &CollapsedProfiler{w: w}
+	for _, opt := range copts {
+		opt(c)
+	}
+
+	p, err := startProfile(s, opts, collapsedStackFormat, true)
+	if err != nil {
+		return nil, err
+	}
+
+	c.p = p
+	return c, nil
+}
+
+// Stop stops the profiler and writes the samples collected.
+func (c *CollapsedProfiler) Stop() error {
+	c.p.stop()
+
+	c.p.mu.Lock()
+	counts := make(map[string]int)
+	for k, n := range c.p.samples {
+		if stack := c.collapse(k); stack != "" {
+			counts[stack] += n
+		}
+	}
+	c.p.mu.Unlock()
+
+	stacks := make([]string, 0, len(counts))
+	for stack := range counts {
+		stacks = append(stacks, stack)
+	}
+	slices.Sort(stacks)
+
+	w := bufio.NewWriter(c.w)
+	for _, stack := range stacks {
+		w.WriteString(stack)
+		w.WriteByte(' ')
+		w.WriteString(strconv.Itoa(counts[stack]))
+		w.WriteByte('\n')
+	}
+
+	return w.Flush()
+}
+
+// goChunk is the chunk name of the Lua functions wrapping Go functions.
+const goChunk = "go-luajit"
+
+// collapse returns the collapsed stack of the samples of k, or "" if they're dropped.
+func (c *CollapsedProfiler) collapse(k profileKey) string {
+	var frames []string
+	inGo := false
+	for _, f := range strings.Split(strings.TrimSuffix(k.stack, ";"), ";") {
+		if f == "" {
+			continue
+		}
+
+		// C functions are the only frames without a chunk.
+		chunk, isLua := "", false
+		if i := strings.LastIndexByte(f, ':'); i >= 0 {
+			chunk, isLua = f[:i], true
+		}
+
+		if c.goFrames {
+			// A Go function is called by a wrapper from goChunk, through C functions.
+			if chunk == goChunk || (inGo && !isLua) {
+				if !inGo {
+					frames = append(frames, "[go]")
+				}
+				inGo = true
+				continue
+			}
+
+			inGo = false
+		}
+
+		if isLua && c.filter != nil && !c.filter(chunk) {
+			continue
+		}
+
+		frames = append(frames, f)
+	}
+
+	if len(frames) == 0 {
+		return ""
+	}
+
+	if c.vmstates {
+		frames = slices.Insert(frames, 0, "["+k.vmstate.String()+"]")
+	}
+
+	return strings.Join(frames, ";")
+}
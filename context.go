@@ -0,0 +1,34 @@
+package luajit
+
+import (
+	"context"
+)
+
+// CallContext calls fn like Function.Call, interrupting it with an error wrapping ctx.Err()
+// once ctx is done.
+//
+// Go functions called by fn that take a context.Context as their first parameter receive ctx,
+// so they can return early; the call is interrupted as soon as they return.
+// Functions that ignore ctx are not interrupted.
+//
+// Interruption works as for CallWithLimits, and the state stays usable afterwards.
+func (s State) CallContext(ctx context.Context, fn *Function, args ...any) ([]Value, error) {
+	lim := &limiter{ctx: ctx}
+	if lim.interrupted() != nil {
+		return nil, lim.err
+	}
+
+	return s.callLimited(fn, lim, args)
+}
+
+// DoStringContext loads and runs the given string like DoString, interrupting it once ctx is done
+// (see CallContext).
+func (s State) DoStringContext(ctx context.Context, src string) error {
+	fn, err := s.LoadString(src, src)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.CallContext(ctx, fn)
+	return err
+}
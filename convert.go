@@ -0,0 +1,109 @@
+package luajit
+
+import (
+	"context"
+	"encoding"
+	"reflect"
+)
+
+var (
+	valueType           = reflect.TypeFor[Value]()
+	tableType           = reflect.TypeFor[*Table]()
+	functionType        = reflect.TypeFor[*Function]()
+	coroutineType       = reflect.TypeFor[*Coroutine]()
+	errorType           = reflect.TypeFor[error]()
+	contextType         = reflect.TypeFor[context.Context]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// UnsupportedTypeError is returned when a Go type cannot be converted to or from Lua.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "luajit: unsupported type " + e.Type.String()
+}
+
+// TypeError describes a Lua value that does not match the Go type it's converted to.
+type TypeError struct {
+	Expected string // What the Go type expected
+	Got      string // The Lua type name of the value
+}
+
+func (e *TypeError) Error() string {
+	return "expected " + e.Expected + ", got " + e.Got
+}
+
+// UnmarshalError is returned when a Lua value cannot be converted to a Go value.
+type UnmarshalError struct {
+	Path string // The location of the value within the original one, such as "servers[2].port"
+	Err  error
+}
+
+func (e *UnmarshalError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// canConvert returns if values of type t can be converted to and from Lua.
+func canConvert(t reflect.Type) bool {
+	return convertible(t, make(map[reflect.Type]bool))
+}
+
+func convertible(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return true
+	}
+	seen[t] = true
+
+	switch t {
+	case valueType, tableType, functionType, coroutineType:
+		return true
+	}
+
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Interface,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Chan:
+		return convertible(t.Elem(), seen)
+	case reflect.Map:
+		return convertible(t.Key(), seen) && convertible(t.Elem(), seen)
+	case reflect.Struct:
+		for _, f := range cachedFields(t) {
+			if !convertible(f.typ, seen) {
+				return false
+			}
+		}
+		return true
+	case reflect.Func:
+		for i := range t.NumIn() {
+			if !convertible(t.In(i), seen) {
+				return false
+			}
+		}
+		for i := range t.NumOut() {
+			if !convertible(t.Out(i), seen) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
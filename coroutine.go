@@ -0,0 +1,207 @@
+package luajit
+
+import (
+	"errors"
+	"iter"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// Coroutine is a reference to a Lua coroutine, which Go code can resume.
+//
+// The thread of the coroutine is kept alive until Close is called or the Coroutine is garbage collected.
+type Coroutine struct {
+	ref     *reference
+	co      lua.State
+	running bool
+}
+
+// CoroutineStatus is the status of a coroutine.
+type CoroutineStatus int
+
+const (
+	CoroutineSuspended CoroutineStatus = iota // Not started yet, or yielded
+	CoroutineRunning                          // Running
+	CoroutineDead                             // Finished, failed or closed
+	CoroutineNormal                           // Resuming another coroutine
+)
+
+func (s CoroutineStatus) String() string {
+	switch s {
+	case CoroutineSuspended:
+		return "suspended"
+	case CoroutineRunning:
+		return "running"
+	case CoroutineNormal:
+		return "normal"
+	default:
+		return "dead"
+	}
+}
+
+var (
+	errDeadCoroutine    = errors.New("luajit: cannot resume dead coroutine")
+	errRunningCoroutine = errors.New("luajit: cannot resume non-suspended coroutine")
+)
+
+// NewCoroutine creates a coroutine that runs fn when first resumed.
+func (s State) NewCoroutine(fn *Function) (*Coroutine, error) {
+	L := s.thread()
+	co := lua.NewThread(L)
+	ref := popReference(L)
+
+	ref.co = co
+	states.Lock()
+	states.m[co] = ref.d
+	states.Unlock()
+
+	if err := fn.ref.pushTo(L); err != nil {
+		ref.release()
+		return nil, err
+	}
+
+	lua.XMove(L, co, 1)
+	return &Coroutine{ref: ref, co: co}, nil
+}
+
+// Resume starts or continues the coroutine.
+//
+// The first time, args are passed to its function.
+// Afterwards, they are returned by the coroutine.yield call that suspended it.
+// The values passed to coroutine.yield are returned, or the ones returned by the function once done is true.
+// If the coroutine fails, it's dead and the error is returned.
+func (c *Coroutine) Resume(args ...any) (vals []Value, done bool, err error) {
+	return c.resume(func(L lua.State) (int, error) {
+		return len(args), pushValues(L, args...)
+	})
+}
+
+// resume resumes the coroutine with the values push pushes onto L.
+func (c *Coroutine) resume(push func(L lua.State) (int, error)) (vals []Value, done bool, err error) {
+	switch c.Status() {
+	case CoroutineDead:
+		return nil, true, errDeadCoroutine
+	case CoroutineRunning, CoroutineNormal:
+		return nil, false, errRunningCoroutine
+	}
+
+	// Values are pushed onto the running thread, as converting them may call Lua functions.
+	L, co := c.ref.thread(), c.co
+	nargs, err := push(L)
+	if err != nil {
+		return nil, false, err
+	}
+
+	lua.XMove(L, co, nargs)
+
+	c.running = true
+	status := lua.Resume(co, nargs)
+	c.running = false
+
+	switch status {
+	case lua.StatusOk, lua.StatusYield:
+		vals = make([]Value, lua.GetTop(co))
+		for i := range vals {
+			vals[i] = toValue(co, i+1)
+		}
+
+		lua.SetTop(co, 0)
+		return vals, status == lua.StatusOk, nil
+	default:
+		err := popError(co, status)
+		lua.SetTop(co, 0)
+		return nil, true, err
+	}
+}
+
+// Status returns the status of the coroutine.
+func (c *Coroutine) Status() CoroutineStatus {
+	if c.ref.id == lua.RefNil {
+		return CoroutineDead
+	}
+
+	if c.ref.thread() == c.co {
+		return CoroutineRunning
+	}
+
+	// Coroutines resumed from Go or Lua that aren't running are resuming another one.
+	if c.running {
+		return CoroutineNormal
+	}
+
+	switch lua.Status(c.co) {
+	case lua.StatusYield:
+		return CoroutineSuspended
+	case lua.StatusOk:
+		if c.active() {
+			return CoroutineNormal
+		}
+
+		// Threads that haven't started have their function on their stack, finished ones have nothing.
+		if lua.GetTop(c.co) > 0 {
+			return CoroutineSuspended
+		}
+	}
+
+	return CoroutineDead
+}
+
+// active returns whether the coroutine has frames, as when it's running or resuming another.
+func (c *Coroutine) active() bool {
+	L := c.ref.thread()
+	lua.CheckStack(L, 2)
+	pushHelper(L, "active")
+	c.ref.push(L)
+	lua.Call(L, 1, 1)
+	active := lua.ToBoolean(L, -1)
+	lua.Pop(L, 1)
+	return active
+}
+
+// Close releases the coroutine, which is dead afterwards.
+//
+// Running coroutines, and those resuming another, cannot be closed.
+func (c *Coroutine) Close() error {
+	if c.ref.id == lua.RefNil {
+		return nil
+	}
+
+	if s := c.Status(); s == CoroutineRunning || s == CoroutineNormal {
+		return errors.New("luajit: cannot close " + s.String() + " coroutine")
+	}
+
+	lua.SetTop(c.co, 0)
+	c.ref.release()
+	return nil
+}
+
+// All returns an iterator resuming the coroutine until it's done,
+// producing the values passed to each coroutine.yield call.
+//
+// The values returned by the function when it finishes are not produced.
+// If the coroutine fails, the error is produced last.
+// Stopping the loop early leaves the coroutine suspended.
+func (c *Coroutine) All() iter.Seq2[[]Value, error] {
+	return func(yield func([]Value, error) bool) {
+		for {
+			vals, done, err := c.Resume()
+			switch {
+			case err != nil:
+				yield(nil, err)
+				return
+			case done:
+				return
+			case !yield(vals, nil):
+				return
+			}
+		}
+	}
+}
+
+// Value returns the coroutine as a Value.
+func (c *Coroutine) Value() Value {
+	L := c.ref.thread()
+	c.ref.push(L)
+	defer lua.Pop(L, 1)
+	return Value{typ: lua.TThread, p: lua.ToPointer(L, -1), ref: c.ref}
+}
@@ -0,0 +1,550 @@
+package luajit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+var (
+	errNotInteger = errors.New("number has no integer representation")
+	errCycle      = errors.New("table contains a cycle")
+)
+
+// decode converts the Lua value at idx and stores it in v, which must be settable.
+func decode(L lua.State, idx int, v reflect.Value) error {
+	d := decoder{L: L}
+	return d.decode(absIndex(L, idx), v)
+}
+
+// decodeAny converts the Lua value at idx to its natural Go representation.
+func decodeAny(L lua.State, idx int, out *any) error {
+	return decode(L, idx, reflect.ValueOf(out).Elem())
+}
+
+// absIndex converts idx to an index that stays valid when values are pushed.
+func absIndex(L lua.State, idx int) int {
+	if idx < 0 && idx > lua.RegistryIndex {
+		return lua.GetTop(L) + idx + 1
+	}
+
+	return idx
+}
+
+type decoder struct {
+	L        lua.State
+	path     []string         // Location of the value being decoded, such as ".servers", "[2]"
+	visiting map[uintptr]bool // Tables currently being decoded
+}
+
+// error wraps err with the current path.
+func (d *decoder) error(err error) error {
+	path := strings.Join(d.path, "")
+	return &UnmarshalError{Path: strings.TrimPrefix(path, "."), Err: err}
+}
+
+func (d *decoder) typeError(idx int, expected string) error {
+	return d.error(&TypeError{Expected: expected, Got: lua.TypeNameOf(d.L, idx)})
+}
+
+// pushPath appends the key at idx to the path.
+func (d *decoder) pushPath(idx int) {
+	L := d.L
+	switch lua.Type(L, idx) {
+	case lua.TNumber:
+		d.path = append(d.path, "["+formatNumber(float64(lua.ToNumber(L, idx)))+"]")
+	case lua.TString:
+		d.path = append(d.path, pathKey(lua.ToString(L, idx)))
+	default:
+		d.path = append(d.path, "["+lua.TypeNameOf(L, idx)+"]")
+	}
+}
+
+func (d *decoder) popPath() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+// pathKey formats a string key the way it would be written in Lua.
+func pathKey(k string) string {
+	ident := k != ""
+	for i, c := range k {
+		if c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && (i == 0 || !('0' <= c && c <= '9')) {
+			ident = false
+			break
+		}
+	}
+
+	if ident {
+		return "." + k
+	}
+
+	return "[" + strconv.Quote(k) + "]"
+}
+
+func (d *decoder) decode(idx int, v reflect.Value) error {
+	L := d.L
+	t := lua.Type(L, idx)
+
+	switch v.Type() {
+	case valueType:
+		v.Set(reflect.ValueOf(toValue(L, idx)))
+		return nil
+	case tableType:
+		switch t {
+		case lua.TNone, lua.TNil:
+			v.SetZero()
+		case lua.TTable:
+			v.Set(reflect.ValueOf(&Table{ref: newReference(L, idx)}))
+		default:
+			return d.typeError(idx, "table")
+		}
+		return nil
+	case functionType:
+		switch t {
+		case lua.TNone, lua.TNil:
+			v.SetZero()
+		case lua.TFunction:
+			v.Set(reflect.ValueOf(&Function{ref: newReference(L, idx)}))
+		default:
+			return d.typeError(idx, "function")
+		}
+		return nil
+	case coroutineType:
+		switch t {
+		case lua.TNone, lua.TNil:
+			v.SetZero()
+		case lua.TThread:
+			v.Set(reflect.ValueOf(&Coroutine{ref: newReference(L, idx), co: lua.ToThread(L, idx)}))
+		default:
+			return d.typeError(idx, "thread")
+		}
+		return nil
+	}
+
+	// Go values passed through Lua are returned as-is, or copied if they are pointers to the expected type.
+	if h := toHandle(L, idx); h != nil {
+		hv := reflect.ValueOf(h)
+		switch {
+		case hv.Type().AssignableTo(v.Type()):
+			v.Set(hv)
+			return nil
+		case hv.Kind() == reflect.Pointer && hv.Type().Elem().AssignableTo(v.Type()):
+			v.Set(hv.Elem())
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if t == lua.TNone || t == lua.TNil {
+			v.SetZero()
+			return nil
+		}
+
+		n, err := d.natural(idx)
+		if err != nil {
+			return err
+		}
+
+		nv := reflect.ValueOf(n)
+		if !nv.Type().AssignableTo(v.Type()) {
+			return d.typeError(idx, v.Type().String())
+		}
+
+		v.Set(nv)
+		return nil
+	case reflect.Pointer:
+		if t == lua.TNone || t == lua.TNil {
+			v.SetZero()
+			return nil
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		return d.decode(idx, v.Elem())
+	}
+
+	if t == lua.TString && v.CanAddr() && reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		u := v.Addr().Interface().(encoding.TextUnmarshaler)
+		if err := u.UnmarshalText([]byte(lua.ToString(L, idx))); err != nil {
+			return d.error(err)
+		}
+
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if t != lua.TBoolean {
+			return d.typeError(idx, "boolean")
+		}
+		v.SetBool(lua.ToBoolean(L, idx))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t != lua.TNumber {
+			return d.typeError(idx, "number")
+		}
+		n := float64(lua.ToNumber(L, idx))
+		if n != math.Trunc(n) {
+			return d.error(errNotInteger)
+		}
+		if n < math.MinInt64 || n >= math.MaxInt64 || v.OverflowInt(int64(n)) {
+			return d.error(fmt.Errorf("number %s overflows %s", formatNumber(n), v.Type()))
+		}
+		v.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if t != lua.TNumber {
+			return d.typeError(idx, "number")
+		}
+		n := float64(lua.ToNumber(L, idx))
+		if n != math.Trunc(n) {
+			return d.error(errNotInteger)
+		}
+		if n < 0 || n >= math.MaxUint64 || v.OverflowUint(uint64(n)) {
+			return d.error(fmt.Errorf("number %s overflows %s", formatNumber(n), v.Type()))
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		if t != lua.TNumber {
+			return d.typeError(idx, "number")
+		}
+		v.SetFloat(float64(lua.ToNumber(L, idx)))
+	case reflect.String:
+		if t != lua.TString {
+			return d.typeError(idx, "string")
+		}
+		v.SetString(lua.ToString(L, idx))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && t == lua.TString {
+			v.SetBytes([]byte(lua.ToString(L, idx)))
+			return nil
+		}
+		if t == lua.TNone || t == lua.TNil {
+			v.SetZero()
+			return nil
+		}
+		if t != lua.TTable {
+			return d.typeError(idx, "table")
+		}
+		n := lua.ObjLen(L, idx)
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		return d.decodeArray(idx, v)
+	case reflect.Array:
+		if t != lua.TTable {
+			return d.typeError(idx, "table")
+		}
+		return d.decodeArray(idx, v)
+	case reflect.Map:
+		if t == lua.TNone || t == lua.TNil {
+			v.SetZero()
+			return nil
+		}
+		if t != lua.TTable {
+			return d.typeError(idx, "table")
+		}
+		return d.decodeMap(idx, v)
+	case reflect.Struct:
+		if t != lua.TTable {
+			return d.typeError(idx, "table")
+		}
+		return d.decodeStruct(idx, v)
+	case reflect.Func:
+		switch t {
+		case lua.TNone, lua.TNil:
+			v.SetZero()
+		case lua.TFunction:
+			v.Set(luaFunc(newReference(L, idx), v.Type()))
+		default:
+			return d.typeError(idx, "function")
+		}
+	default:
+		return d.error(&UnsupportedTypeError{Type: v.Type()})
+	}
+
+	return nil
+}
+
+// enter marks the table at idx as being decoded, failing if it already is.
+func (d *decoder) enter(idx int) (leave func(), err error) {
+	p := lua.ToPointer(d.L, idx)
+	if d.visiting[p] {
+		return nil, d.error(errCycle)
+	}
+
+	if d.visiting == nil {
+		d.visiting = make(map[uintptr]bool)
+	}
+
+	d.visiting[p] = true
+	lua.CheckStack(d.L, 3)
+	return func() { delete(d.visiting, p) }, nil
+}
+
+func (d *decoder) decodeArray(idx int, v reflect.Value) error {
+	leave, err := d.enter(idx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	L := d.L
+	for i := range v.Len() {
+		lua.RawGetI(L, idx, i+1)
+		d.path = append(d.path, "["+strconv.Itoa(i+1)+"]")
+		err := d.decode(lua.GetTop(L), v.Index(i))
+		d.popPath()
+		lua.Pop(L, 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *decoder) decodeMap(idx int, v reflect.Value) error {
+	leave, err := d.enter(idx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+
+	L := d.L
+	lua.PushNil(L)
+	for lua.Next(L, idx) {
+		top := lua.GetTop(L)
+		d.pushPath(top - 1)
+
+		key := reflect.New(t.Key()).Elem()
+		err := d.decode(top-1, key)
+
+		elem := reflect.New(t.Elem()).Elem()
+		if err == nil {
+			err = d.decode(top, elem)
+		}
+
+		d.popPath()
+		if err != nil {
+			lua.Pop(L, 2)
+			return err
+		}
+
+		v.SetMapIndex(key, elem)
+		lua.Pop(L, 1)
+	}
+
+	return nil
+}
+
+func (d *decoder) decodeStruct(idx int, v reflect.Value) error {
+	leave, err := d.enter(idx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	L := d.L
+	for _, f := range cachedFields(v.Type()) {
+		lua.PushLString(L, f.name, len(f.name))
+		lua.RawGet(L, idx)
+		if lua.IsNil(L, -1) {
+			lua.Pop(L, 1)
+			continue
+		}
+
+		fv, ok := fieldByIndex(v, f.index, true)
+		if !ok {
+			lua.Pop(L, 1)
+			continue
+		}
+
+		d.path = append(d.path, pathKey(f.name))
+		err := d.decode(lua.GetTop(L), fv)
+		d.popPath()
+		lua.Pop(L, 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// natural converts the value at idx to its natural Go representation (see Value.Interface).
+func (d *decoder) natural(idx int) (any, error) {
+	L := d.L
+	switch lua.Type(L, idx) {
+	case lua.TNone, lua.TNil:
+		return nil, nil
+	case lua.TBoolean:
+		return lua.ToBoolean(L, idx), nil
+	case lua.TNumber:
+		return float64(lua.ToNumber(L, idx)), nil
+	case lua.TString:
+		return lua.ToString(L, idx), nil
+	case lua.TFunction:
+		return &Function{ref: newReference(L, idx)}, nil
+	case lua.TTable:
+		return d.naturalTable(idx)
+	}
+
+	if h := toHandle(L, idx); h != nil {
+		return h, nil
+	}
+
+	return toValue(L, idx), nil
+}
+
+func (d *decoder) naturalTable(idx int) (any, error) {
+	leave, err := d.enter(idx)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	type entry struct{ k, v any }
+
+	var (
+		entries  []entry
+		sequence = true
+		strings  = true
+	)
+
+	L := d.L
+	lua.PushNil(L)
+	for lua.Next(L, idx) {
+		top := lua.GetTop(L)
+
+		var k any
+		switch lua.Type(L, top-1) {
+		case lua.TNumber:
+			n := float64(lua.ToNumber(L, top-1))
+			k = n
+			strings = false
+			sequence = sequence && n == math.Trunc(n) && n >= 1
+		case lua.TString:
+			k = lua.ToString(L, top-1)
+			sequence = false
+		case lua.TBoolean:
+			k = lua.ToBoolean(L, top-1)
+			sequence, strings = false, false
+		default:
+			// Keys that are references stay as values, as they must be comparable.
+			k = toValue(L, top-1)
+			sequence, strings = false, false
+		}
+
+		d.pushPath(top - 1)
+		v, err := d.natural(top)
+		d.popPath()
+		if err != nil {
+			lua.Pop(L, 2)
+			return nil, err
+		}
+
+		entries = append(entries, entry{k, v})
+		lua.Pop(L, 1)
+	}
+
+	switch {
+	case len(entries) == 0:
+		return map[string]any{}, nil
+	case sequence:
+		out := make([]any, len(entries))
+		for _, e := range entries {
+			i := int(e.k.(float64))
+			if i > len(out) {
+				sequence = false
+				break
+			}
+			out[i-1] = e.v
+		}
+		if sequence {
+			return out, nil
+		}
+	case strings:
+		out := make(map[string]any, len(entries))
+		for _, e := range entries {
+			out[e.k.(string)] = e.v
+		}
+		return out, nil
+	}
+
+	out := make(map[any]any, len(entries))
+	for _, e := range entries {
+		out[e.k] = e.v
+	}
+	return out, nil
+}
+
+// luaFunc creates a Go function of type t that calls the referenced Lua function.
+//
+// If t's last result is an error, Lua errors are returned through it; otherwise they panic.
+func luaFunc(ref *reference, t reflect.Type) reflect.Value {
+	nout := t.NumOut()
+	returnsErr := nout > 0 && t.Out(nout-1) == errorType
+	if returnsErr {
+		nout--
+	}
+
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.New(t.Out(i)).Elem()
+		}
+
+		fail := func(err error) []reflect.Value {
+			if !returnsErr {
+				panic(err)
+			}
+
+			out[nout].Set(reflect.ValueOf(&err).Elem())
+			return out
+		}
+
+		if t.IsVariadic() && len(args) > 0 {
+			last := args[len(args)-1]
+			args = args[:len(args)-1]
+			for i := range last.Len() {
+				args = append(args, last.Index(i))
+			}
+		}
+
+		L := ref.thread()
+		top := lua.GetTop(L)
+		lua.CheckStack(L, len(args)+1)
+
+		ref.push(L)
+		for _, a := range args {
+			if err := encode(L, a); err != nil {
+				lua.SetTop(L, top)
+				return fail(err)
+			}
+		}
+
+		if status := lua.PCall(L, len(args), nout, 0); status != lua.StatusOk {
+			return fail(popError(L, status))
+		}
+
+		defer lua.SetTop(L, top)
+		for i := range nout {
+			if err := decode(L, top+i+1, out[i]); err != nil {
+				return fail(fmt.Errorf("result #%d: %w", i+1, err))
+			}
+		}
+
+		return out
+	})
+}
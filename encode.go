@@ -0,0 +1,278 @@
+package luajit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// pushValue converts v to a Lua value and pushes it onto the stack.
+//
+// Nothing is pushed if an error is returned.
+func pushValue(L lua.State, v any) error {
+	return encode(L, reflect.ValueOf(v))
+}
+
+// pushValues pushes each value, or nothing if an error is returned.
+func pushValues(L lua.State, vs ...any) error {
+	top := lua.GetTop(L)
+	lua.CheckStack(L, len(vs))
+	for _, v := range vs {
+		if err := pushValue(L, v); err != nil {
+			lua.SetTop(L, top)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushKey pushes v, failing if it cannot be used as a table key.
+func pushKey(L lua.State, v any) error {
+	e := encoder{L: L}
+	return e.encodeKey(reflect.ValueOf(v))
+}
+
+// encode pushes the Lua representation of v.
+//
+// Nothing is pushed if an error is returned.
+func encode(L lua.State, v reflect.Value) error {
+	e := encoder{L: L}
+	return e.encode(v)
+}
+
+type encoder struct {
+	L        lua.State
+	visiting map[visit]bool // References currently being encoded
+}
+
+// visit identifies a reference being encoded, to detect cycles.
+type visit struct {
+	p   uintptr
+	typ reflect.Type
+	len int
+}
+
+func (e *encoder) encodeKey(v reflect.Value) error {
+	if err := e.encode(v); err != nil {
+		return err
+	}
+
+	L := e.L
+	switch {
+	case lua.IsNil(L, -1):
+		lua.Pop(L, 1)
+		return errors.New("luajit: table key is nil")
+	case lua.Type(L, -1) == lua.TNumber && math.IsNaN(float64(lua.ToNumber(L, -1))):
+		lua.Pop(L, 1)
+		return errors.New("luajit: table key is NaN")
+	}
+
+	return nil
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	L := e.L
+	if !v.IsValid() {
+		lua.PushNil(L)
+		return nil
+	}
+
+	switch v.Type() {
+	case valueType:
+		return v.Interface().(Value).push(L)
+	case tableType:
+		if v.IsNil() {
+			lua.PushNil(L)
+			return nil
+		}
+		return v.Interface().(*Table).ref.pushTo(L)
+	case functionType:
+		if v.IsNil() {
+			lua.PushNil(L)
+			return nil
+		}
+		return v.Interface().(*Function).ref.pushTo(L)
+	case coroutineType:
+		if v.IsNil() || v.Interface().(*Coroutine).ref.id == lua.RefNil {
+			lua.PushNil(L)
+			return nil
+		}
+		return v.Interface().(*Coroutine).ref.pushTo(L)
+	case sharedDictType:
+		if v.IsNil() {
+			lua.PushNil(L)
+			return nil
+		}
+		pushHandle(L, v.Interface(), dictMeta)
+		return nil
+	}
+
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		lua.PushNil(L)
+		return nil
+	}
+
+	if v.Kind() != reflect.Interface {
+		if v.Kind() != reflect.Pointer && v.CanAddr() && reflect.PointerTo(v.Type()).Implements(textMarshalerType) {
+			v = v.Addr()
+		}
+
+		if v.Type().Implements(textMarshalerType) {
+			text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return fmt.Errorf("luajit: marshaling %s: %w", v.Type(), err)
+			}
+
+			lua.PushLString(L, string(text), len(text))
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		lua.PushBoolean(L, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		lua.PushNumber(L, lua.Number(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		lua.PushNumber(L, lua.Number(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		lua.PushNumber(L, lua.Number(v.Float()))
+	case reflect.String:
+		s := v.String()
+		lua.PushLString(L, s, len(s))
+	case reflect.Slice:
+		if v.IsNil() {
+			lua.PushNil(L)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			s := string(v.Bytes())
+			lua.PushLString(L, s, len(s))
+			return nil
+		}
+		return e.encodeRef(v, visit{v.Pointer(), v.Type(), v.Len()}, e.encodeArray)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			lua.PushNil(L)
+			return nil
+		}
+		return e.encodeRef(v, visit{p: v.Pointer(), typ: v.Type()}, e.encodeMap)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Pointer:
+		return e.encodeRef(v, visit{p: v.Pointer(), typ: v.Type()}, func(v reflect.Value) error {
+			return e.encode(v.Elem())
+		})
+	case reflect.Interface:
+		if v.IsNil() {
+			lua.PushNil(L)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Chan:
+		if v.IsNil() {
+			lua.PushNil(L)
+			return nil
+		}
+		pushHandle(L, v.Interface(), chanMeta)
+	case reflect.Func:
+		if v.IsNil() {
+			lua.PushNil(L)
+			return nil
+		}
+		c, err := reflectCallable(v.Type().String(), v, nil, false)
+		if err != nil {
+			return err
+		}
+		pushCallable(L, c)
+	default:
+		return &UnsupportedTypeError{Type: v.Type()}
+	}
+
+	return nil
+}
+
+// encodeRef encodes v with fn, failing if v is already being encoded.
+func (e *encoder) encodeRef(v reflect.Value, key visit, fn func(reflect.Value) error) error {
+	if e.visiting[key] {
+		return fmt.Errorf("luajit: encountered a cycle via %s", v.Type())
+	}
+
+	if e.visiting == nil {
+		e.visiting = make(map[visit]bool)
+	}
+
+	e.visiting[key] = true
+	defer delete(e.visiting, key)
+	return fn(v)
+}
+
+func (e *encoder) encodeArray(v reflect.Value) error {
+	L := e.L
+	lua.CheckStack(L, 2)
+	lua.CreateTable(L, v.Len(), 0)
+	for i := range v.Len() {
+		if err := e.encode(v.Index(i)); err != nil {
+			lua.Pop(L, 1)
+			return err
+		}
+
+		lua.RawSetI(L, -2, i+1)
+	}
+
+	return nil
+}
+
+func (e *encoder) encodeMap(v reflect.Value) error {
+	L := e.L
+	lua.CheckStack(L, 3)
+	lua.CreateTable(L, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		if err := e.encodeKey(iter.Key()); err != nil {
+			lua.Pop(L, 1)
+			return err
+		}
+
+		if err := e.encode(iter.Value()); err != nil {
+			lua.Pop(L, 2)
+			return err
+		}
+
+		lua.RawSet(L, -3)
+	}
+
+	return nil
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) error {
+	L := e.L
+	fields := cachedFields(v.Type())
+
+	lua.CheckStack(L, 2)
+	lua.CreateTable(L, 0, len(fields))
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index, false)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+
+		if err := e.encode(fv); err != nil {
+			lua.Pop(L, 1)
+			return err
+		}
+
+		lua.PushLString(L, f.name, len(f.name))
+		lua.Insert(L, -2)
+		lua.RawSet(L, -3)
+	}
+
+	return nil
+}
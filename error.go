@@ -0,0 +1,104 @@
+package luajit
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// Error is returned when Lua code fails.
+type Error struct {
+	Status  int    // The status returned by the VM (lua.ErrRun, lua.ErrSyntax, etc.)
+	Message string // The error message
+	Err     error  // The Go error that caused the failure, if any
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ArgError is returned by Go functions called from Lua when an argument cannot be converted.
+type ArgError struct {
+	Arg  int    // The argument position, starting at 1
+	Func string // The name of the function
+	Err  error
+}
+
+func (e *ArgError) Error() string {
+	// Type errors of the argument itself are worded the same as the ones raised by Lua.
+	if ue, ok := e.Err.(*UnmarshalError); ok && ue.Path == "" {
+		if te, ok := ue.Err.(*TypeError); ok {
+			return fmt.Sprintf("bad argument #%d to '%s' (%s expected, got %s)", e.Arg, e.Func, te.Expected, te.Got)
+		}
+	}
+
+	return fmt.Sprintf("bad argument #%d to '%s' (%v)", e.Arg, e.Func, e.Err)
+}
+
+func (e *ArgError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	errNoField  = errors.New("no such field")
+	errReadOnly = errors.New("read-only")
+)
+
+// fieldError is returned when a field of a Go object cannot be assigned from Lua.
+type fieldError struct {
+	Type  reflect.Type
+	Field string
+	Err   error
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("cannot set field '%s' of %s (%v)", e.Field, e.Type, e.Err)
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.Err
+}
+
+// popError pops the error at the top of the stack.
+func popError(L lua.State, status int) error {
+	defer lua.Pop(L, 1)
+
+	e := &Error{Status: status}
+	if err, ok := toHandle(L, -1).(error); ok {
+		e.Message = err.Error()
+		e.Err = err
+		return e
+	}
+
+	if lua.IsString(L, -1) {
+		e.Message = lua.ToString(L, -1)
+	} else {
+		e.Message = "(error object is a " + lua.TypeNameOf(L, -1) + " value)"
+	}
+
+	return e
+}
+
+// pushError pushes err as a Lua error value.
+//
+// Go errors are kept as userdata so they can be recovered with errors.Is and errors.As
+// once they reach Go code again.
+func pushError(L lua.State, err error) {
+	// Errors caused by the script are raised as strings, so Lua can add position information.
+	var (
+		argErr   *ArgError
+		fieldErr *fieldError
+	)
+	if errors.As(err, &argErr) || errors.As(err, &fieldErr) {
+		lua.PushString(L, err.Error())
+		return
+	}
+
+	pushHandle(L, err, errorMeta)
+}
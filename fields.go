@@ -0,0 +1,198 @@
+package luajit
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// field is a struct field converted to and from a table key.
+type field struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	tagged    bool // The name came from a 'lua' tag
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedFields returns the fields of the struct type t.
+func cachedFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]field)
+}
+
+// typeFields returns the fields of t, following the same rules as encoding/json:
+//
+//   - Exported fields are named by their 'lua' tag, or their Go name if they don't have one.
+//   - Fields tagged with "-" are ignored.
+//   - Fields of embedded structs without a tag are promoted into t.
+//   - If several fields share a name, the shallowest one is used.
+//     If there's more than one at that depth, the tagged one is used.
+//     Otherwise, they are all ignored.
+func typeFields(t reflect.Type) []field {
+	type level struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var (
+		fields  []field
+		next    = []level{{typ: t}}
+		visited = map[reflect.Type]bool{}
+	)
+
+	for len(next) > 0 {
+		current := next
+		next = nil
+
+		for _, l := range current {
+			if visited[l.typ] {
+				continue
+			}
+			visited[l.typ] = true
+
+			for i := range l.typ.NumField() {
+				sf := l.typ.Field(i)
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+
+				if sf.Anonymous {
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag := sf.Tag.Get("lua")
+				if tag == "-" {
+					continue
+				}
+
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(slices.Clip(l.index), i)
+
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, level{typ: ft, index: index})
+					continue
+				}
+
+				if !sf.IsExported() {
+					continue
+				}
+
+				f := field{
+					name:      name,
+					index:     index,
+					typ:       sf.Type,
+					tagged:    name != "",
+					omitEmpty: slices.Contains(strings.Split(opts, ","), "omitempty"),
+				}
+				if f.name == "" {
+					f.name = sf.Name
+				}
+
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	// Keep the dominant field for each name.
+	slices.SortStableFunc(fields, func(a, b field) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+
+		if c := len(a.index) - len(b.index); c != 0 {
+			return c
+		}
+
+		switch {
+		case a.tagged && !b.tagged:
+			return -1
+		case !a.tagged && b.tagged:
+			return 1
+		}
+
+		return 0
+	})
+
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+
+		if f, ok := dominantField(fields[i:j]); ok {
+			out = append(out, f)
+		}
+
+		i = j
+	}
+
+	slices.SortFunc(out, func(a, b field) int {
+		return slices.Compare(a.index, b.index)
+	})
+
+	return out
+}
+
+// dominantField returns the field that wins among fields sharing a name, sorted by depth then tag.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+
+	return fields[0], true
+}
+
+// fieldByIndex returns the field of v at index.
+//
+// Nil embedded pointers are allocated if alloc is true.
+// Otherwise, or if they cannot be allocated, ok is false.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (f reflect.Value, ok bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(x)
+	}
+
+	return v, true
+}
+
+// isEmptyValue returns if v is considered empty by the omitempty option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+
+	return false
+}
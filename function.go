@@ -0,0 +1,42 @@
+package luajit
+
+import (
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// Function is a reference to a Lua function.
+type Function struct {
+	ref *reference
+}
+
+// Call calls the function in protected mode with the given arguments and returns its results.
+func (f *Function) Call(args ...any) ([]Value, error) {
+	L := f.ref.thread()
+	top := lua.GetTop(L)
+
+	f.ref.push(L)
+	if err := pushValues(L, args...); err != nil {
+		lua.SetTop(L, top)
+		return nil, err
+	}
+
+	if status := lua.PCall(L, len(args), lua.MultRet, 0); status != lua.StatusOk {
+		return nil, popError(L, status)
+	}
+
+	results := make([]Value, lua.GetTop(L)-top)
+	for i := range results {
+		results[i] = toValue(L, top+i+1)
+	}
+
+	lua.SetTop(L, top)
+	return results, nil
+}
+
+// Value returns the function as a Value.
+func (f *Function) Value() Value {
+	L := f.ref.thread()
+	f.ref.push(L)
+	defer lua.Pop(L, 1)
+	return Value{typ: lua.TFunction, p: lua.ToPointer(L, -1), ref: f.ref}
+}
@@ -0,0 +1,242 @@
+package luajit
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// OpenGo makes require("go") return a module running Lua functions in parallel, each in a new state
+// on its own goroutine:
+//
+//	local go = require("go")
+//	local h = go.run(fn, ...)  -- Runs fn with copies of the arguments
+//	local done = h:done()      -- Whether fn returned or failed
+//	local a, b = h:wait()      -- Copies of the results of fn, or raises its error
+//
+// fn is a function without upvalues, or a string of source code.
+// Functions are dumped to bytecode, so they can only use their arguments and the globals of the new state.
+// Strings of bytecode are refused unless WithBytecodeChunks is used.
+// Arguments and results are copied as the messages of actors (see ActorSystem).
+//
+// The new states are created by NewState with the standard libraries open, unless WithRunStateFactory is used,
+// and are then passed to init if it isn't nil.
+// States running untrusted scripts should use a factory calling NewSandbox, so that go.run doesn't give
+// the scripts access to more libraries than they have.
+//
+// wait suspends the calling coroutine when it's run by a Scheduler, and blocks otherwise.
+// The package library must be open.
+func (s State) OpenGo(init func(s State) error, opts ...GoOption) error {
+	m := &goModule{init: init}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return s.PreloadModule("go", func(s State) (any, error) {
+		L := s.thread()
+		lua.NewTable(L)
+		pushCallable(L, func(L lua.State) (int, error) {
+			return goRun(L, m)
+		})
+		lua.SetField(L, -2, "run")
+		return &Table{ref: popReference(L)}, nil
+	})
+}
+
+// GoOption configures the module opened by State.OpenGo.
+type GoOption func(*goModule)
+
+// WithRunStateFactory creates the states running the functions with fn, such as a function calling NewSandbox,
+// instead of NewState and State.OpenLibs.
+func WithRunStateFactory(fn func() (State, error)) GoOption {
+	return func(m *goModule) {
+		m.factory = fn
+	}
+}
+
+// WithBytecodeChunks lets go.run run strings of bytecode.
+// Malformed bytecode can crash the process, so it must only be used with trusted scripts.
+func WithBytecodeChunks() GoOption {
+	return func(m *goModule) {
+		m.bytecode = true
+	}
+}
+
+// goModule is the configuration of the module opened by State.OpenGo.
+type goModule struct {
+	init     func(s State) error
+	factory  func() (State, error)
+	bytecode bool
+}
+
+// newState creates and initializes a state running a function.
+func (m *goModule) newState() (State, error) {
+	var s State
+	if m.factory != nil {
+		var err error
+		if s, err = m.factory(); err != nil {
+			return 0, err
+		}
+	} else {
+		s = NewState()
+		s.OpenLibs()
+	}
+
+	if m.init != nil {
+		if err := m.init(s); err != nil {
+			s.Close()
+			return 0, err
+		}
+	}
+
+	return s, nil
+}
+
+// goRunMeta is the metatable of the handles returned by go.run.
+var goRunMeta = &metatable{name: "go-luajit.run"}
+
+func init() {
+	goRunMeta.init = initGoRunMeta
+}
+
+func initGoRunMeta(L lua.State) {
+	lua.CreateTable(L, 0, 2)
+	pushCallable(L, goRunDone)
+	lua.SetField(L, -2, "done")
+	pushCallable(L, goRunWait)
+	lua.SetField(L, -2, "wait")
+	lua.SetField(L, -2, "__index")
+}
+
+// parallelRun is a function running in a new state.
+type parallelRun struct {
+	done    chan struct{}
+	results []any // Detached values
+	err     error
+}
+
+func goRun(L lua.State, m *goModule) (int, error) {
+	var code []byte
+	switch lua.Type(L, 1) {
+	case lua.TFunction:
+		b, err := dumpFunction(L, 1)
+		if err != nil {
+			return 0, &ArgError{Arg: 1, Func: "run", Err: err}
+		}
+		code = b
+	case lua.TString:
+		code = lua.ToBytes(L, 1)
+		if len(code) > 0 && code[0] == lua.Signature[0] && !m.bytecode {
+			return 0, &ArgError{Arg: 1, Func: "run", Err: errors.New("attempt to load a binary chunk")}
+		}
+	default:
+		err := &TypeError{Expected: "function", Got: lua.TypeNameOf(L, 1)}
+		return 0, &ArgError{Arg: 1, Func: "run", Err: &UnmarshalError{Err: err}}
+	}
+
+	args := make([]any, max(lua.GetTop(L)-1, 0))
+	for i := range args {
+		v, err := detach(L, i+2)
+		if err != nil {
+			return 0, &ArgError{Arg: i + 2, Func: "run", Err: err}
+		}
+		args[i] = v
+	}
+
+	r := &parallelRun{done: make(chan struct{})}
+	go r.run(code, args, m)
+
+	pushHandle(L, r, goRunMeta)
+	return 1, nil
+}
+
+// run runs code with args in a new state.
+func (r *parallelRun) run(code []byte, args []any, m *goModule) {
+	defer close(r.done)
+
+	s, err := m.newState()
+	if err != nil {
+		r.err = err
+		return
+	}
+	defer s.Close()
+
+	L := s.thread()
+	if status := lua.LoadBuffer(L, code, "=go.run"); status != lua.StatusOk {
+		r.err = errors.New(popError(L, status).Error())
+		return
+	}
+
+	for _, v := range args {
+		if r.err = attach(L, v); r.err != nil {
+			return
+		}
+	}
+
+	if status := lua.PCall(L, len(args), lua.MultRet, 0); status != lua.StatusOk {
+		// The error may refer to values of the state, which is closed afterwards.
+		r.err = errors.New(popError(L, status).Error())
+		return
+	}
+
+	r.results = make([]any, lua.GetTop(L))
+	for i := range r.results {
+		if r.results[i], r.err = detach(L, i+1); r.err != nil {
+			r.results = nil
+			return
+		}
+	}
+}
+
+// checkRun returns the handle the method name is called on.
+func checkRun(L lua.State, name string) (*parallelRun, error) {
+	r, ok := toHandle(L, 1).(*parallelRun)
+	if !ok {
+		err := &TypeError{Expected: "go.run handle", Got: lua.TypeNameOf(L, 1)}
+		return nil, &ArgError{Arg: 1, Func: name, Err: &UnmarshalError{Err: err}}
+	}
+
+	return r, nil
+}
+
+func goRunDone(L lua.State) (int, error) {
+	r, err := checkRun(L, "done")
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-r.done:
+		lua.PushBoolean(L, true)
+	default:
+		lua.PushBoolean(L, false)
+	}
+
+	return 1, nil
+}
+
+func goRunWait(L lua.State) (int, error) {
+	r, err := checkRun(L, "wait")
+	if err != nil {
+		return 0, err
+	}
+
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.done)}}
+	return block(L, cases, func(L lua.State, _ int, _ reflect.Value, _ bool) (int, error) {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		top := lua.GetTop(L)
+		lua.CheckStack(L, len(r.results))
+		for _, v := range r.results {
+			if err := attach(L, v); err != nil {
+				lua.SetTop(L, top)
+				return 0, err
+			}
+		}
+
+		return len(r.results), nil
+	})
+}
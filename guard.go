@@ -0,0 +1,12 @@
+//go:build !luajit_debug
+
+package luajit
+
+// guard checks that a state is used by the goroutine owning it, when built with the luajit_debug tag.
+//
+// Without the tag, it does nothing.
+type guard struct{}
+
+func (g *guard) adopt() {}
+
+func (g *guard) check() {}
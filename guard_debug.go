@@ -0,0 +1,44 @@
+//go:build luajit_debug
+
+package luajit
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// guard checks that a state is used by the goroutine owning it.
+type guard struct {
+	owner atomic.Int64
+}
+
+// adopt makes the calling goroutine the owner of the state.
+func (g *guard) adopt() {
+	g.owner.Store(goid())
+}
+
+// check panics if the calling goroutine doesn't own the state.
+func (g *guard) check() {
+	if id, owner := goid(), g.owner.Load(); id != owner {
+		panic(fmt.Sprintf("luajit: state owned by goroutine %d used by goroutine %d; "+
+			"states are not safe for concurrent use, see State.Adopt and Locked", owner, id))
+	}
+}
+
+// goid returns the ID of the calling goroutine, parsed from its stack trace.
+func goid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	b = b[:max(bytes.IndexByte(b, ' '), 0)]
+
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		panic("luajit: unable to get the goroutine ID: " + err.Error())
+	}
+
+	return id
+}
@@ -0,0 +1,176 @@
+package luajit
+
+import (
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+const helpersKey = "go-luajit.helpers"
+
+// helpersSource is run once per state.
+// It returns a table of Lua functions the wrapper relies on.
+//
+// Go code must never raise Lua errors itself, as unwinding through Go frames is not possible.
+// Instead, Go functions are wrapped so they report errors to Lua code which raises them.
+//
+// It receives the base, debug, string and jit libraries, which don't need to be open in the state.
+const helpersSource = `
+local base, debug, string, jit = ...
+local error, next, pcall, type, unpack = base.error, base.next, base.pcall, base.type, base.unpack
+local yield = base.coroutine.yield
+local getmetatable, getupvalue, sethook, gethook = debug.getmetatable, debug.getupvalue, debug.sethook, debug.gethook
+local getinfo = debug.getinfo
+local dump = string.dump
+local jitstatus = jit.status
+
+-- check returns the results of a Go function, raises its error, or yields on its behalf.
+-- Whoever resumes the coroutine then passes the results of the Go function in the same form.
+local function check(ok, ...)
+	if ok then
+		return ...
+	end
+
+	if ok == false then
+		local err, level = ...
+		error(err, level)
+	end
+
+	return check(yield(...))
+end
+
+local helpers = {}
+
+function helpers.wrap(f)
+	return function(...)
+		return check(f(...))
+	end
+end
+
+function helpers.get(t, k)
+	return t[k]
+end
+
+function helpers.set(t, k, v)
+	t[k] = v
+end
+
+-- pairs returns the iterator function, state and initial value to traverse t.
+-- If raw is false, the __pairs metamethod of t is used when it has one.
+function helpers.pairs(t, raw)
+	local mt = getmetatable(t)
+	if not raw and type(mt) == "table" and mt.__pairs then
+		return mt.__pairs(t)
+	end
+
+	return next, t, nil
+end
+
+-- active returns whether the thread co has frames, as when it's running or resuming another thread.
+function helpers.active(co)
+	return getinfo(co, 0, "l") ~= nil
+end
+
+-- jiton returns whether the JIT compiler is on.
+function helpers.jiton()
+	return (jitstatus())
+end
+
+-- limit calls f every count instructions until the returned function is called.
+-- Once f fails, its error is raised at every instruction so that scripts cannot catch it for long.
+function helpers.limit(f, count)
+	local old = { gethook() }
+	if type(old[1]) ~= "function" then
+		old = {}
+	end
+
+	local function hook()
+		local ok, err = f()
+		if not ok then
+			sethook(hook, "", 1)
+			error(err, 0)
+		end
+	end
+
+	sethook(hook, "", count)
+	return function()
+		sethook(unpack(old, 1, 3))
+	end
+end
+
+-- dump returns the bytecode of the Lua function f, or nil and a message if f cannot be reloaded from it.
+function helpers.dump(f)
+	if getupvalue(f, 1) ~= nil then
+		return nil, "function has upvalues"
+	end
+
+	local ok, b = pcall(dump, f)
+	if not ok then
+		return nil, b
+	end
+
+	return b
+end
+
+return helpers
+`
+
+// openHelpers creates the helpers table for a new state.
+func openHelpers(L lua.State) {
+	if status := lua.LoadBuffer(L, []byte(helpersSource), "=go-luajit"); status != lua.StatusOk {
+		panic("luajit: unable to load helpers: " + lua.ToString(L, -1))
+	}
+
+	openHidden(L, lua.BaseLibName, lua.OpenBase)
+	openHidden(L, lua.DbLibName, lua.OpenDebug)
+
+	// Opening the string library sets the metatable of strings, which must stay as it was.
+	lua.PushString(L, "")
+	if !lua.GetMetatable(L, -1) {
+		lua.PushNil(L)
+	}
+
+	// f base debug "" mt
+	openHidden(L, lua.StringLibName, lua.OpenString) // f base debug "" mt string
+	lua.Insert(L, -3)                                // f base debug string "" mt
+	lua.SetMetatable(L, -2)                          // f base debug string ""
+	lua.Pop(L, 1)                                    // f base debug string
+
+	openHidden(L, lua.JitLibName, lua.OpenJit) // f base debug string jit
+
+	lua.Call(L, 4, 1)
+	lua.SetField(L, lua.RegistryIndex, helpersKey)
+}
+
+// openHidden opens a standard library and pushes its table, without making it visible to scripts.
+//
+// The library is opened with empty globals and package.loaded, which are restored afterwards.
+func openHidden(L lua.State, name string, open func(L lua.State) int) {
+	top := lua.GetTop(L)
+	lua.CheckStack(L, 4)
+
+	lua.PushValue(L, lua.GlobalsIndex)            // G
+	lua.GetField(L, lua.RegistryIndex, "_LOADED") // G loaded
+	lua.NewTable(L)                               // G loaded env
+	lua.PushValue(L, -1)                          // G loaded env env
+	lua.Replace(L, lua.GlobalsIndex)              // G loaded env
+	lua.NewTable(L)                               // G loaded env {}
+	lua.SetField(L, lua.RegistryIndex, "_LOADED") // G loaded env
+
+	open(L)
+	lua.SetTop(L, top+3)
+
+	if name != lua.BaseLibName {
+		lua.GetField(L, -1, name) // G loaded env lib
+		lua.Replace(L, -2)        // G loaded lib
+	}
+
+	lua.Insert(L, top+1)                          // lib G loaded
+	lua.SetField(L, lua.RegistryIndex, "_LOADED") // lib G
+	lua.Replace(L, lua.GlobalsIndex)              // lib
+}
+
+// pushHelper pushes the helper function name onto the stack.
+func pushHelper(L lua.State, name string) {
+	lua.GetField(L, lua.RegistryIndex, helpersKey)
+	lua.GetField(L, -1, name)
+	lua.Remove(L, -2)
+}
@@ -0,0 +1,170 @@
+package luajit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+var (
+	// ErrBudgetExceeded is returned when a call runs more instructions than allowed by its Limits.
+	ErrBudgetExceeded = errors.New("luajit: instruction budget exceeded")
+
+	// ErrDeadlineExceeded is returned when a call runs past the deadline of its Limits.
+	ErrDeadlineExceeded = errors.New("luajit: deadline exceeded")
+)
+
+// Limits restricts how long a call can run.
+type Limits struct {
+	Instructions int       // The maximum number of VM instructions to run, or 0 for no limit
+	Deadline     time.Time // The time after which the call is interrupted, or the zero time for none
+
+	// KeepCompiled keeps the code compiled before the call instead of flushing it,
+	// at the cost of that code running without checking the limits.
+	KeepCompiled bool
+}
+
+// hookInterval is the number of instructions between checks of the limits.
+//
+// Limits are enforced with this precision, and time spent in Go functions isn't interrupted.
+const hookInterval = 1000
+
+// CallWithLimits calls fn like Function.Call, interrupting it with ErrBudgetExceeded or ErrDeadlineExceeded
+// if it goes beyond limits.
+//
+// Once interrupted, the error is raised again at every instruction,
+// so scripts that catch it with pcall are interrupted as well.
+// The state stays usable afterwards.
+//
+// As compiled code doesn't check hooks, the JIT compiler is flushed and turned off during the call,
+// then restored to its previous mode. Limits.KeepCompiled skips the flush.
+// Debug hooks set by scripts are replaced during the call, and restored afterwards.
+func (s State) CallWithLimits(fn *Function, limits Limits, args ...any) ([]Value, error) {
+	return s.callLimited(fn, &limiter{limits: limits}, args)
+}
+
+// callLimited calls fn, checking lim while it runs.
+func (s State) callLimited(fn *Function, lim *limiter, args []any) ([]Value, error) {
+	var results []Value
+	err := s.runLimited(lim, func() error {
+		var err error
+		results, err = fn.Call(args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// runLimited calls run, checking lim while the Lua code it calls runs, including in coroutines.
+// If lim interrupts the Lua code, its error is returned instead of the one of run.
+func (s State) runLimited(lim *limiter, run func() error) error {
+	L := s.thread()
+	d := s.data()
+
+	lim.count = hookInterval
+	if lim.limits.Instructions > 0 {
+		lim.count = min(lim.count, lim.limits.Instructions)
+	}
+
+	// Calls nested in a call with a context are interrupted with it.
+	if lim.ctx == nil {
+		lim.ctx = d.context()
+	}
+
+	if d.limited == 0 {
+		d.jitOn = jitOn(L)
+		lua.SetMode(L, 0, lua.ModeEngine|lua.ModeOff)
+		if !lim.limits.KeepCompiled {
+			lua.SetMode(L, 0, lua.ModeEngine|lua.ModeFlush)
+		}
+	}
+	d.limited++
+
+	outer := d.lim
+	d.lim = lim
+
+	pushHelper(L, "limit")
+	pushDispatch(L, lim.check)
+	lua.PushInteger(L, lua.Integer(lim.count))
+	lua.Call(L, 2, 1)
+	restore := lua.Ref(L, lua.RegistryIndex)
+
+	err := run()
+
+	lua.RawGetI(L, lua.RegistryIndex, restore)
+	lua.Unref(L, lua.RegistryIndex, restore)
+	lua.Call(L, 0, 0)
+
+	d.lim = outer
+	d.limited--
+	if d.limited == 0 && d.jitOn {
+		lua.SetMode(L, 0, lua.ModeEngine|lua.ModeOn)
+	}
+
+	if lim.err != nil {
+		return lim.err
+	}
+
+	return err
+}
+
+// jitOn returns whether the JIT compiler is on.
+func jitOn(L lua.State) bool {
+	pushHelper(L, "jiton")
+	lua.Call(L, 0, 1)
+	on := lua.ToBoolean(L, -1)
+	lua.Pop(L, 1)
+	return on
+}
+
+// context returns the context of the innermost call running with one.
+func (d *stateData) context() context.Context {
+	if d.lim != nil {
+		return d.lim.ctx
+	}
+
+	return context.Background()
+}
+
+// limiter checks the limits of a call.
+type limiter struct {
+	limits Limits
+	ctx    context.Context
+	count  int   // The number of instructions between checks
+	used   int   // The number of instructions run so far
+	err    error // Why the call was interrupted
+}
+
+// check is called by the debug hook.
+func (lim *limiter) check(L lua.State) (int, error) {
+	if lim.err == nil {
+		lim.used += lim.count
+		if lim.limits.Instructions > 0 && lim.used >= lim.limits.Instructions {
+			lim.err = ErrBudgetExceeded
+		}
+	}
+
+	return 0, lim.interrupted()
+}
+
+// interrupted returns why the call must be interrupted, or nil if it can continue.
+func (lim *limiter) interrupted() error {
+	if lim.err != nil {
+		return lim.err
+	}
+
+	switch {
+	case !lim.limits.Deadline.IsZero() && !time.Now().Before(lim.limits.Deadline):
+		lim.err = ErrDeadlineExceeded
+	case lim.ctx.Err() != nil:
+		lim.err = fmt.Errorf("luajit: call interrupted: %w", lim.ctx.Err())
+	}
+
+	return lim.err
+}
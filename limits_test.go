@@ -0,0 +1,40 @@
+package luajit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCallWithLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		limits Limits
+		want   error
+	}{
+		{"budget", `while true do end`, Limits{Instructions: 100000}, ErrBudgetExceeded},
+		{"deadline", `while true do end`, Limits{Deadline: time.Now().Add(50 * time.Millisecond)}, ErrDeadlineExceeded},
+		{"pcall", `while true do pcall(function() while true do end end) end`, Limits{Instructions: 100000}, ErrBudgetExceeded},
+		{"coroutine", `coroutine.wrap(function() while true do end end)()`, Limits{Instructions: 100000}, ErrBudgetExceeded},
+		{"within", `local n = 0 for i = 1, 100 do n = n + i end return n`, Limits{Instructions: 100000}, nil},
+	}
+
+	s := newTestState(t)
+	for _, tt := range tests {
+		fn, err := s.LoadString(tt.src, "="+tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.CallWithLimits(fn, tt.limits)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// The state stays usable once a call is interrupted.
+	if err := s.DoString(`x = 1 + 1`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+package luajit
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// ErrStateClosed is returned by the methods of a LockedState once its state is closed.
+var ErrStateClosed = errors.New("luajit: state closed")
+
+// LockedState serializes the use of a state with a mutex, so that it can be shared by goroutines.
+//
+// Only the calls made through the LockedState are serialized: its methods, which forward to the methods
+// of State that don't return Lua objects, and Do for everything else.
+// Methods returning Lua objects, such as State.LoadString or State.Globals, have no counterpart:
+// they must be called within Do, and the values they return, such as tables and functions, only used there.
+type LockedState struct {
+	d *stateData
+	s State
+}
+
+// Locked returns a LockedState using s.
+//
+// Every LockedState of a state shares the same lock.
+// s must not be used directly afterwards, only through a LockedState.
+func Locked(s State) *LockedState {
+	return &LockedState{d: s.load(), s: s}
+}
+
+// Do calls fn with the state, holding the lock until it returns.
+// It fails with ErrStateClosed once the state is closed.
+func (ls *LockedState) Do(fn func(s State) error) error {
+	ls.d.lock.Lock()
+	defer ls.d.lock.Unlock()
+
+	// The state may have been closed through another LockedState, and must not be touched.
+	if ls.d.closed {
+		return ErrStateClosed
+	}
+
+	ls.d.adopt()
+	return fn(ls.s)
+}
+
+// DoString loads and runs the given string, see State.DoString.
+func (ls *LockedState) DoString(src string) error {
+	return ls.Do(func(s State) error {
+		return s.DoString(src)
+	})
+}
+
+// DoStringContext loads and runs the given string until ctx is done, see State.DoStringContext.
+func (ls *LockedState) DoStringContext(ctx context.Context, src string) error {
+	return ls.Do(func(s State) error {
+		return s.DoStringContext(ctx, src)
+	})
+}
+
+// SetGlobal sets the global name to v, see State.SetGlobal.
+func (ls *LockedState) SetGlobal(name string, v any) error {
+	return ls.Do(func(s State) error {
+		return s.SetGlobal(name, v)
+	})
+}
+
+// RegisterFunc sets the global name to the Go function fn, see State.RegisterFunc.
+//
+// fn is called with the lock held, and must not use the LockedState.
+func (ls *LockedState) RegisterFunc(name string, fn any) error {
+	return ls.Do(func(s State) error {
+		return s.RegisterFunc(name, fn)
+	})
+}
+
+// OpenLibs opens all standard Lua libraries into the state, see State.OpenLibs.
+func (ls *LockedState) OpenLibs() {
+	ls.Do(func(s State) error {
+		s.OpenLibs()
+		return nil
+	})
+}
+
+// OpenGo makes require("go") return the module running functions in parallel, see State.OpenGo.
+func (ls *LockedState) OpenGo(init func(s State) error, opts ...GoOption) error {
+	return ls.Do(func(s State) error {
+		return s.OpenGo(init, opts...)
+	})
+}
+
+// RegisterAsync sets the global name to the Go function fn, see State.RegisterAsync.
+func (ls *LockedState) RegisterAsync(name string, fn AsyncFunc) error {
+	return ls.Do(func(s State) error {
+		return s.RegisterAsync(name, fn)
+	})
+}
+
+// PreloadModule makes require(name) return the value created by loader, see State.PreloadModule.
+//
+// loader is called with the lock held, and must not use the LockedState.
+func (ls *LockedState) PreloadModule(name string, loader func(s State) (any, error)) error {
+	return ls.Do(func(s State) error {
+		return s.PreloadModule(name, loader)
+	})
+}
+
+// AddSearcher makes require find modules in fsys, see State.AddSearcher.
+func (ls *LockedState) AddSearcher(fsys fs.FS, pattern string) error {
+	return ls.Do(func(s State) error {
+		return s.AddSearcher(fsys, pattern)
+	})
+}
+
+// Close closes the state, see State.Close. The other calls fail afterwards, and closing it again does nothing.
+func (ls *LockedState) Close() {
+	ls.Do(func(s State) error {
+		s.Close()
+		return nil
+	})
+}
@@ -16,8 +16,17 @@ const (
 	ErrFile = ErrErr + 1 // A file cannot be open/read
 )
 
+const (
+	NoRef  = -2 // Returned by Ref when it cannot create a reference
+	RefNil = -1 // Returned by Ref when the object at the top of the stack is nil
+)
+
 // NewState creates a new Lua state.
 func NewState() State {
+	if loadErr != nil {
+		panic(loadErr)
+	}
+
 	return luaL.newstate()
 }
 
@@ -81,6 +90,32 @@ func LoadString(L State, s string) int {
 	return int(luaL.loadstring(L, s))
 }
 
+// LoadBuffer loads a buffer as a Lua chunk.
+//
+// 'name' is the chunk name, used for debug information and error messages.
+// This function returns the same results as Load.
+func LoadBuffer(L State, buff []byte, name string) int {
+	if len(buff) == 0 {
+		return LoadString(L, "")
+	}
+
+	return int(luaL.loadbuffer(L, &buff[0], size_t(len(buff)), name))
+}
+
+// Ref creates and returns a reference, in the table at index t, for the object at the top of the stack (and pops the object).
+//
+// A reference is a unique integer key.
+// If the object at the top of the stack is nil, Ref returns RefNil.
+func Ref(L State, t int) int {
+	return int(luaL.ref(L, int32(t)))
+}
+
+// Unref releases reference ref from the table at index t.
+// The entry is removed from the table, so that the referred object can be collected.
+func Unref(L State, t int, ref int) {
+	luaL.unref(L, int32(t), int32(ref))
+}
+
 /// Macro conversions
 
 // ArgCheck checks whether cond is true.
@@ -153,6 +188,10 @@ var luaL struct {
 	where  func(L State, lvl int32)               `lua:"luaL_where"`
 	error_ func(L State, fmt string, args ...any) `lua:"luaL_error"`
 
-	newstate   func() State                  `lua:"luaL_newstate"`
-	loadstring func(L State, s string) int32 `lua:"luaL_loadstring"`
+	ref   func(L State, t int32) int32      `lua:"luaL_ref"`
+	unref func(L State, t int32, ref int32) `lua:"luaL_unref"`
+
+	newstate   func() State                                            `lua:"luaL_newstate"`
+	loadstring func(L State, s string) int32                           `lua:"luaL_loadstring"`
+	loadbuffer func(L State, buff *byte, sz size_t, name string) int32 `lua:"luaL_loadbuffer"`
 }
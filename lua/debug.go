@@ -0,0 +1,94 @@
+package lua
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// dumps holds the buffers of the Dump calls in progress, by ID.
+var dumps struct {
+	sync.Mutex
+	last uintptr
+	m    map[uintptr]*[]byte
+}
+
+// dumpWriter is the lua_Writer used by Dump, created once as callbacks are never released.
+var dumpWriter = sync.OnceValue(func() uintptr {
+	return purego.NewCallback(func(L State, p *byte, sz size_t, ud uintptr) int32 {
+		dumps.Lock()
+		buf := dumps.m[ud]
+		dumps.Unlock()
+
+		*buf = append(*buf, unsafe.Slice(p, sz)...)
+		return 0
+	})
+})
+
+// Dump dumps the Lua function at the top of the stack as a binary chunk, which LoadBuffer loads back.
+// The function is not popped.
+//
+// Upvalues are not part of the chunk: once loaded, the function has as many upvalues, all nil.
+// It returns 0 on success, or the error returned by the writer.
+func Dump(L State) ([]byte, int) {
+	var buf []byte
+
+	dumps.Lock()
+	if dumps.m == nil {
+		dumps.m = make(map[uintptr]*[]byte)
+	}
+	dumps.last++
+	id := dumps.last
+	dumps.m[id] = &buf
+	dumps.Unlock()
+
+	status := lua.dump(L, dumpWriter(), id)
+
+	dumps.Lock()
+	delete(dumps.m, id)
+	dumps.Unlock()
+
+	return buf, int(status)
+}
+
+// GetUpvalue pushes the value of upvalue n of the closure at funcindex onto the stack, and returns its name.
+// It returns false and pushes nothing if the closure has no such upvalue.
+//
+// The upvalues of C functions have an empty name.
+func GetUpvalue(L State, funcindex, n int) (string, bool) {
+	return cstring(lua.getupvalue(L, int32(funcindex), int32(n)))
+}
+
+// SetUpvalue pops a value from the stack and sets it as the new value of upvalue n of the closure at funcindex,
+// and returns its name.
+// It returns false and pops nothing if the closure has no such upvalue.
+func SetUpvalue(L State, funcindex, n int) (string, bool) {
+	return cstring(lua.setupvalue(L, int32(funcindex), int32(n)))
+}
+
+// UpvalueID returns a unique identifier for upvalue n of the closure at funcindex.
+//
+// Closures sharing an upvalue get the same identifier for it.
+func UpvalueID(L State, funcindex, n int) uintptr {
+	return lua.upvalueid(L, int32(funcindex), int32(n))
+}
+
+// UpvalueJoin makes upvalue n1 of the Lua closure at f1 refer to upvalue n2 of the Lua closure at f2.
+func UpvalueJoin(L State, f1, n1, f2, n2 int) {
+	lua.upvaluejoin(L, int32(f1), int32(n1), int32(f2), int32(n2))
+}
+
+// cstring returns the null-terminated string at p, or false if p is nil.
+func cstring(p *byte) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+
+	n := 0
+	for *(*byte)(unsafe.Add(unsafe.Pointer(p), n)) != 0 {
+		n++
+	}
+
+	return string(unsafe.Slice(p, n)), true
+}
@@ -1,5 +1,14 @@
 package lua
 
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
 const (
 	JitVersion   = "LuaJIT 2.1.1724232689"
 	JitCopyright = "Copyright (C) 2005-2023 Mike Pall"
@@ -29,6 +38,18 @@ const (
 // ProfileCallback represents a function used for profiling.
 type ProfileCallback func(data uintptr, L State, samples, vmstate int32)
 
+// ProfileFunc represents a ProfileCallback that has already been converted to a C function pointer.
+//
+// A ProfileFunc can be used any number of times without allocating.
+type ProfileFunc uintptr
+
+// NewProfileFunc converts cb into a ProfileFunc.
+//
+// The resulting function is never released, so this should only be called once per callback.
+func NewProfileFunc(cb ProfileCallback) ProfileFunc {
+	return ProfileFunc(purego.NewCallback(cb))
+}
+
 // SetMode allows control of the VM.
 //
 // 'idx' is expected to be 0 or a stack index.
@@ -46,19 +67,113 @@ func ProfileStart(L State, mode string, cb ProfileCallback, data uintptr) {
 	jit.profile_start(L, mode, cb, data)
 }
 
+// ProfileStartFunc starts the profiler like ProfileStart, with a callback created by NewProfileFunc.
+func ProfileStartFunc(L State, mode string, fn ProfileFunc, data uintptr) {
+	jit.profile_startfunc(L, mode, fn, data)
+}
+
 // ProfileStop stops the profiler.
 func ProfileStop(L State) {
 	jit.profile_stop(L)
 }
 
-// ProfileDumpStack allows taking stack dumps in an effecient manner.
-func ProfileDumpStack(L State, fmt string, depth int, len *uint) string {
-	return jit.profile_dumpstack(L, fmt, int32(depth), len)
+// ProfileFrame is a frame of a stack dumped by ProfileDumpStack.
+type ProfileFrame struct {
+	Module   string // The chunk name of the function, or "[C]" for C functions
+	Function string // The name of the function, or "module:line" with the line it's defined at if it has none
+	Line     int    // The line being run, or 0 for C functions
+}
+
+// profileFrameFormat dumps every frame as "module:function\tmodule:line\n".
+// C functions have no module, and "[C]" as their location.
+const profileFrameFormat = "pF\tpl\n"
+
+// ProfileDumpStack returns up to depth frames of the stack, starting from the innermost one.
+// A negative depth returns up to -depth frames, starting from the outermost one.
+//
+// It must be called from a profiler callback.
+func ProfileDumpStack(L State, depth int) []ProfileFrame {
+	stack := ProfileDumpStackBytes(L, profileFrameFormat, depth)
+
+	var frames []ProfileFrame
+	for len(stack) > 0 {
+		var line []byte
+		line, stack, _ = bytes.Cut(stack, []byte("\n"))
+
+		fn, loc, _ := bytes.Cut(line, []byte("\t"))
+		f := ProfileFrame{Module: string(loc), Function: string(fn)}
+		if i := bytes.LastIndexByte(loc, ':'); i >= 0 {
+			if n, err := strconv.Atoi(string(loc[i+1:])); err == nil {
+				f.Module, f.Line = string(loc[:i]), n
+
+				// Anonymous functions keep their module, as they're named after a line of it.
+				name := strings.TrimPrefix(f.Function, f.Module+":")
+				if _, err := strconv.Atoi(name); err != nil {
+					f.Function = name
+				}
+			}
+		}
+
+		frames = append(frames, f)
+	}
+
+	return frames
+}
+
+// VMState represents the state of the VM when the profiler took a sample.
+type VMState int32
+
+const (
+	VMNative      = VMState('N') // Running compiled code
+	VMInterpreted = VMState('I') // Running interpreted code
+	VMCFunction   = VMState('C') // Running a C function
+	VMGC          = VMState('G') // Collecting garbage
+	VMCompiler    = VMState('J') // Compiling code
+)
+
+func (v VMState) String() string {
+	switch v {
+	case VMNative:
+		return "compiled"
+	case VMInterpreted:
+		return "interpreted"
+	case VMCFunction:
+		return "C"
+	case VMGC:
+		return "GC"
+	case VMCompiler:
+		return "JIT compiler"
+	}
+
+	return string(rune(v))
+}
+
+// ProfileFrameCallback adapts fn into a ProfileCallback,
+// passing it up to depth frames of the stack sampled as returned by ProfileDumpStack.
+func ProfileFrameCallback(depth int, fn func(frames []ProfileFrame, samples int, vmstate VMState)) ProfileCallback {
+	return func(_ uintptr, L State, samples, vmstate int32) {
+		fn(ProfileDumpStack(L, depth), int(samples), VMState(vmstate))
+	}
+}
+
+// ProfileDumpStackBytes returns a copy of a dump of up to depth frames of the stack, each formatted with fmt
+// as described by the documentation of luaJIT_profile_dumpstack.
+//
+// It must be called from a profiler callback.
+func ProfileDumpStackBytes(L State, fmt string, depth int) []byte {
+	var n size_t
+	p := jit.profile_dumpstackptr(L, fmt, int32(depth), &n)
+	if p == nil {
+		return nil
+	}
+
+	return append([]byte{}, unsafe.Slice(p, n)...)
 }
 
 var jit struct {
-	setmode           func(L State, idx int32, mode JitMode) int32                 `lua:"luaJIT_setmode"`
-	profile_start     func(L State, mode string, cb ProfileCallback, data uintptr) `lua:"luaJIT_profile_start"`
-	profile_stop      func(L State)                                                `lua:"luaJIT_profile_stop"`
-	profile_dumpstack func(L State, fmt string, depth int32, len *size_t) string   `lua:"luaJIT_profile_dumpstack"`
+	setmode              func(L State, idx int32, mode JitMode) int32                 `lua:"luaJIT_setmode"`
+	profile_start        func(L State, mode string, cb ProfileCallback, data uintptr) `lua:"luaJIT_profile_start"`
+	profile_startfunc    func(L State, mode string, fn ProfileFunc, data uintptr)     `lua:"luaJIT_profile_start"`
+	profile_stop         func(L State)                                                `lua:"luaJIT_profile_stop"`
+	profile_dumpstackptr func(L State, fmt string, depth int32, len *size_t) *byte    `lua:"luaJIT_profile_dumpstack"`
 }
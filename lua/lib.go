@@ -1,6 +1,7 @@
 package lua
 
 const (
+	BaseLibName      = "_G"
 	CoroutineLibName = "coroutine"
 	MathLibName      = "math"
 	StringLibName    = "string"
@@ -50,10 +51,17 @@ func OpenOs(L State) int {
 }
 
 // OpenPackage opens the package library into the given state.
-func OpenPacakge(L State) int {
+func OpenPackage(L State) int {
 	return int(lib.open_package(L))
 }
 
+// OpenPacakge opens the package library into the given state.
+//
+// Deprecated: Use OpenPackage.
+func OpenPacakge(L State) int {
+	return OpenPackage(L)
+}
+
 // OpenDebug opens the debug library into the given state.
 func OpenDebug(L State) int {
 	return int(lib.open_debug(L))
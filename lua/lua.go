@@ -30,6 +30,12 @@ type (
 // CFunction represents a function used to interact with the Lua VM.
 type CFunction func(L State) (nresults int32)
 
+// Callback represents a CFunction that has already been converted to a C function pointer.
+//
+// Every CFunction passed to the VM allocates a new callback, of which only a limited number can exist.
+// A Callback can be pushed any number of times without allocating.
+type Callback uintptr
+
 const (
 	MultRet       = -1
 	RegistryIndex = -10000
@@ -85,7 +91,7 @@ const (
 
 // Open creates a new Lua state.
 func Open() State {
-	return luaL.newstate()
+	return NewState()
 }
 
 // Close destroys all objects in the given Lua state (calling the corresponding garbage-collection metamethods, if any)
@@ -295,6 +301,18 @@ func PushString(L State, s string) {
 	lua.pushstring(L, s)
 }
 
+// ToBytes returns a copy of the string at the given acceptable index, which may contain embedded zeros.
+// Numbers are converted as by ToLString. It returns nil for other values.
+func ToBytes(L State, idx int) []byte {
+	var n size_t
+	p := lua.tolbytes(L, int32(idx), &n)
+	if p == nil {
+		return nil
+	}
+
+	return append([]byte{}, unsafe.Slice(p, n)...)
+}
+
 // PushLString pushes a string with the value s and the size len onto the stack.
 //
 // Lua makes (or reuses) an internal copy of the given string.
@@ -329,6 +347,20 @@ func PushClosure(L State, fn CFunction, n int) {
 	lua.pushcclosure(L, fn, int32(n))
 }
 
+// PushCallback pushes a new closure for a callback created with NewCallback onto the stack.
+//
+// The maximum value for n is 255.
+func PushCallback(L State, cb Callback, n int) {
+	lua.pushcallback(L, cb, int32(n))
+}
+
+// NewCallback converts fn into a Callback.
+//
+// The resulting callback is never released, so this should only be called once per function.
+func NewCallback(fn CFunction) Callback {
+	return Callback(purego.NewCallback(fn))
+}
+
 // PushFString pushes onto the stack a formatted string and returns the string.
 //
 // The conversion specifiers are quite restricted. There are no flags, widths, or precisions. The conversion specifiers can only be:
@@ -498,6 +530,29 @@ func Next(L State, idx int) bool {
 	return lua.next(L, int32(idx)) == 1
 }
 
+// ForEach calls fn for each key-value pair of the table at the given index, until fn returns false.
+//
+// fn receives the stack indices of the key and the value.
+// It must leave the key unchanged (in particular, it must not call ToString on it, see Next),
+// and the stack is restored to its previous size after each call, even if fn panics.
+func ForEach(L State, idx int, fn func(k, v int) bool) {
+	if idx < 0 && idx > RegistryIndex {
+		idx = GetTop(L) + idx + 1
+	}
+
+	top := GetTop(L)
+	defer SetTop(L, top)
+
+	PushNil(L)
+	for Next(L, idx) {
+		if !fn(top+1, top+2) {
+			return
+		}
+
+		SetTop(L, top+1)
+	}
+}
+
 // Concat concatenates the n values at the top of the stack, pops them, and leaves the result at the top.
 // If n is 1, the result is the single value on the stack (that is, the function does nothing);
 // if n is 0, the result is the empty string.
@@ -621,6 +676,7 @@ var lua struct {
 	tointeger   func(L State, idx int32) Integer             `lua:"lua_tointeger"`
 	toboolean   func(L State, idx int32) bool                `lua:"lua_toboolean"`
 	tolstring   func(L State, idx int32, len *size_t) string `lua:"lua_tolstring"`
+	tolbytes    func(L State, idx int32, len *size_t) *byte  `lua:"lua_tolstring"`
 	objlen      func(L State, idx int32) size_t              `lua:"lua_objlen"`
 	touserdata  func(L State, idx int32) uintptr             `lua:"lua_touserdata"`
 	tothread    func(L State, idx int32) State               `lua:"lua_tothread"`
@@ -636,6 +692,7 @@ var lua struct {
 	pushlightuserdata func(L State, p uintptr)                             `lua:"lua_pushlightuserdata"`
 	pushthread        func(L State) int32                                  `lua:"lua_pushthread"`
 	pushcclosure      func(L State, fn CFunction, n int32)                 `lua:"lua_pushcclosure"`
+	pushcallback      func(L State, fn Callback, n int32)                  `lua:"lua_pushcclosure"`
 	pushfstring       func(L State, fmt string, args []interface{}) string `lua:"lua_pushfstring"`
 
 	gettable     func(L State, idx int32)              `lua:"lua_gettable"`
@@ -663,6 +720,13 @@ var lua struct {
 
 	gc func(L State, what int32, data int32) int32 `lua:"lua_gc"`
 
+	dump func(L State, writer uintptr, data uintptr) int32 `lua:"lua_dump"`
+
+	getupvalue  func(L State, funcindex int32, n int32) *byte         `lua:"lua_getupvalue"`
+	setupvalue  func(L State, funcindex int32, n int32) *byte         `lua:"lua_setupvalue"`
+	upvalueid   func(L State, funcindex int32, n int32) uintptr       `lua:"lua_upvalueid"`
+	upvaluejoin func(L State, f1 int32, n1 int32, f2 int32, n2 int32) `lua:"lua_upvaluejoin"`
+
 	error_ func(L State) int32            `lua:"lua_error"`
 	next   func(L State, idx int32) int32 `lua:"lua_next"`
 	concat func(L State, n int32)         `lua:"lua_concat"`
@@ -670,8 +734,23 @@ var lua struct {
 
 // @todo(judah): maybe it's just better to let the user pass a library handle in
 
+// loadErr is the error of loading the library, if it failed.
+var loadErr error
+
+// LoadError returns the error of loading LuaJIT's library, or nil if it was loaded.
+//
+// Creating a state panics with this error, so that importing the package doesn't.
+func LoadError() error {
+	return loadErr
+}
+
 func init() {
-	handle := openlib()
+	handle, err := openlib()
+	if err != nil {
+		loadErr = err
+		return
+	}
+
 	bindFuncPointers(&lua, handle)
 	bindFuncPointers(&luaL, handle)
 	bindFuncPointers(&lib, handle)
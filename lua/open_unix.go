@@ -6,11 +6,6 @@ import (
 	"github.com/ebitengine/purego"
 )
 
-func openlib() uintptr {
-	handle, err := purego.Dlopen("libluajit.so", purego.RTLD_LAZY|purego.RTLD_GLOBAL)
-	if err != nil {
-		panic(err)
-	}
-
-	return handle
+func openlib() (uintptr, error) {
+	return purego.Dlopen("libluajit.so", purego.RTLD_LAZY|purego.RTLD_GLOBAL)
 }
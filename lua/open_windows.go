@@ -6,11 +6,7 @@ import (
 	"golang.org/x/sys/windows"
 )
 
-func openlib() uintptr {
+func openlib() (uintptr, error) {
 	handle, err := windows.LoadLibrary("libluajit.dll")
-	if err != nil {
-		panic(err)
-	}
-
-	return uintptr(handle)
+	return uintptr(handle), err
 }
@@ -0,0 +1,58 @@
+package luajit
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Marshal converts v to a Lua value and pushes it onto the stack of s.
+// Nothing is pushed if an error is returned.
+//
+// Values are converted as follows:
+//
+//   - nil pointers, interfaces, maps and slices become nil.
+//   - Bools become booleans, integers and floats become numbers.
+//   - Strings and byte slices become strings.
+//   - Types implementing encoding.TextMarshaler become strings.
+//   - Slices and arrays become sequences, starting at index 1.
+//   - Maps become tables, their keys converted with the same rules.
+//   - Structs become tables with a key for each exported field (see below).
+//   - Pointers and interfaces are converted to the value they point to.
+//   - Functions become Lua functions (see State.RegisterFunc).
+//   - Channels become userdata with methods to send and receive values (see Scheduler).
+//   - *SharedDict becomes a userdata sharing the dictionary (see SharedDict).
+//   - Value, *Table, *Function and *Coroutine are pushed as-is.
+//
+// Struct fields are named by their 'lua' tag, which follows the same format as encoding/json:
+//
+//	Port    int    `lua:"port"`            // Stored as "port"
+//	Comment string `lua:"note,omitempty"`  // Stored as "note", omitted if empty
+//	Secret  string `lua:"-"`               // Ignored
+//
+// The fields of embedded structs are promoted as they would be by encoding/json.
+//
+// An error is returned for cyclic data structures and for types that cannot be represented in Lua,
+// such as complex numbers.
+func Marshal(s State, v any) error {
+	return pushValue(s.thread(), v)
+}
+
+// Unmarshal converts the Lua value at idx and stores the result in the value pointed to by v.
+//
+// Unmarshal follows the rules of Marshal in reverse,
+// allocating maps, slices and pointers as needed.
+// Struct fields not present in the table are left unchanged.
+// Lua values are converted to interface values as described by Value.Interface,
+// and Go values pushed as userdata are stored as-is.
+//
+// If a value cannot be converted, an *UnmarshalError is returned,
+// with a path to the offending value such as "servers[2].port".
+// Tables containing cycles cannot be unmarshaled.
+func Unmarshal(s State, idx int, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("luajit: Unmarshal(non-pointer %T)", v)
+	}
+
+	return decode(s.thread(), idx, rv.Elem())
+}
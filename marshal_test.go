@@ -0,0 +1,61 @@
+package luajit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+func TestUnmarshalErrorPath(t *testing.T) {
+	type server struct {
+		Host string `lua:"host"`
+		Port int    `lua:"port"`
+	}
+
+	type config struct {
+		Servers []server          `lua:"servers"`
+		Tags    map[string]string `lua:"tags"`
+	}
+
+	tests := []struct {
+		src  string
+		path string
+	}{
+		{`return {servers = {{host = "a", port = 1}, {host = "b", port = "x"}}}`, "servers[2].port"},
+		{`return {servers = {{host = {}}}}`, "servers[1].host"},
+		{`return {tags = {env = true}}`, "tags.env"},
+		{`return {servers = 1}`, "servers"},
+	}
+
+	s := newTestState(t)
+	for _, tt := range tests {
+		fn, err := s.LoadString(tt.src, "=test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := fn.Call()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Marshal(s, results[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		var c config
+		err = Unmarshal(s, -1, &c)
+		lua.Pop(s.thread(), 1)
+
+		var ue *UnmarshalError
+		if !errors.As(err, &ue) {
+			t.Errorf("%s: got error %v, want an *UnmarshalError", tt.src, err)
+			continue
+		}
+
+		if ue.Path != tt.path {
+			t.Errorf("%s: got path %q, want %q", tt.src, ue.Path, tt.path)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package luajit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+var errNoPackage = errors.New("luajit: package library is not open")
+
+// PreloadModule makes require(name) return the value created by loader.
+//
+// loader is called the first time the module is required, and its result is converted as in State.SetGlobal.
+// The package library must be open.
+func (s State) PreloadModule(name string, loader func(s State) (any, error)) error {
+	L := s.thread()
+	if !pushPackageField(L, "preload") {
+		return errNoPackage
+	}
+
+	pushCallable(L, func(L lua.State) (int, error) {
+		v, err := loader(State(L))
+		if err != nil {
+			return 0, fmt.Errorf("luajit: loading module '%s': %w", name, err)
+		}
+
+		return 1, pushValue(L, v)
+	})
+	lua.SetField(L, -2, name)
+	lua.Pop(L, 1)
+	return nil
+}
+
+// AddSearcher makes require find modules in fsys, after the searchers already installed.
+//
+// pattern is a list of paths separated by semicolons, like package.path.
+// In each path, '?' is replaced by the module name with dots replaced by slashes.
+// If pattern is empty, "?.lua;?/init.lua" is used, so "foo.bar" is loaded from foo/bar.lua or foo/bar/init.lua.
+//
+// Files can contain source code or precompiled bytecode.
+// Their chunk name is their path prefixed by '@', as for files loaded from disk.
+// The package library must be open.
+func (s State) AddSearcher(fsys fs.FS, pattern string) error {
+	if pattern == "" {
+		pattern = "?.lua;?/init.lua"
+	}
+
+	L := s.thread()
+	if !pushPackageField(L, "loaders") {
+		return errNoPackage
+	}
+
+	templates := strings.Split(pattern, ";")
+	pushCallable(L, func(L lua.State) (int, error) {
+		return searchFS(L, fsys, templates)
+	})
+	lua.RawSetI(L, -2, lua.ObjLen(L, -2)+1)
+	lua.Pop(L, 1)
+	return nil
+}
+
+// pushPackageField pushes the table package[name], or nothing if it doesn't exist.
+//
+// The package table is found through package.loaded, so it doesn't need to be a global.
+func pushPackageField(L lua.State, name string) bool {
+	lua.CheckStack(L, 3)
+	lua.GetField(L, lua.RegistryIndex, "_LOADED")
+	if !lua.IsTable(L, -1) {
+		lua.Pop(L, 1)
+		return false
+	}
+
+	lua.GetField(L, -1, lua.LoadLibName)
+	lua.Replace(L, -2)
+	if !lua.IsTable(L, -1) {
+		lua.Pop(L, 1)
+		return false
+	}
+
+	lua.GetField(L, -1, name)
+	lua.Replace(L, -2)
+	if !lua.IsTable(L, -1) {
+		lua.Pop(L, 1)
+		return false
+	}
+
+	return true
+}
+
+// searchFS is a package loader finding modules in fsys.
+//
+// As expected by require, it returns the loaded chunk, or a message listing the files it tried.
+func searchFS(L lua.State, fsys fs.FS, templates []string) (int, error) {
+	if lua.Type(L, 1) != lua.TString {
+		err := &TypeError{Expected: "string", Got: lua.TypeNameOf(L, 1)}
+		return 0, &ArgError{Arg: 1, Func: "searcher", Err: &UnmarshalError{Err: err}}
+	}
+
+	name := lua.ToString(L, 1)
+	file := strings.ReplaceAll(name, ".", "/")
+
+	var tried strings.Builder
+	for _, t := range templates {
+		p := strings.ReplaceAll(t, "?", file)
+		b, err := fs.ReadFile(fsys, p)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+			fmt.Fprintf(&tried, "\n\tno file '%s'", p)
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error loading module '%s' from file '%s':\n\t%w", name, p, err)
+		}
+
+		if status := lua.LoadBuffer(L, b, "@"+p); status != lua.StatusOk {
+			return 0, fmt.Errorf("error loading module '%s' from file '%s':\n\t%w", name, p, popError(L, status))
+		}
+
+		return 1, nil
+	}
+
+	lua.PushString(L, tried.String())
+	return 1, nil
+}
@@ -0,0 +1,283 @@
+package luajit
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// PushObject pushes ptr onto the stack as a userdata that gives Lua live access to the value it points to.
+//
+// Exported fields can be read and assigned by their name (see Marshal for how 'lua' tags rename them).
+// Fields holding structs, or pointers to structs, are exposed as objects themselves,
+// other fields are converted every time they are accessed.
+// Exported methods can be called with Lua's method syntax (obj:Method(...)),
+// their arguments and results converted as described by State.RegisterFunc.
+// Methods with parameters or results that cannot be converted are not exposed.
+//
+// Methods following Go naming conventions implement Lua operators (see MapMetamethod).
+// Methods returning values of the object's own type, or pointers to it, return objects.
+//
+// Pushing the same pointer again returns the same userdata, so equality and table keys work as expected.
+// The pointer is kept alive until Lua collects the userdata.
+func (s State) PushObject(ptr any) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("luajit: PushObject(non-pointer %T)", ptr)
+	}
+
+	pushObject(s.thread(), v)
+	return nil
+}
+
+// objectType describes how a Go pointer type is exposed to Lua.
+type objectType struct {
+	typ         reflect.Type
+	meta        *metatable
+	fields      map[string]field
+	methods     map[string]callable
+	metamethods map[string]callable
+}
+
+var objectTypes struct {
+	sync.Mutex
+	m        map[reflect.Type]*objectType
+	mappings map[reflect.Type]map[string]string // Explicit metamethods, see MapMetamethod
+}
+
+// objectTypeOf returns the objectType of the pointer type t.
+func objectTypeOf(t reflect.Type) *objectType {
+	objectTypes.Lock()
+	defer objectTypes.Unlock()
+
+	if ot, ok := objectTypes.m[t]; ok {
+		return ot
+	}
+
+	if objectTypes.m == nil {
+		objectTypes.m = make(map[reflect.Type]*objectType)
+	}
+
+	ot := &objectType{
+		typ:         t,
+		fields:      make(map[string]field),
+		methods:     make(map[string]callable),
+		metamethods: make(map[string]callable),
+	}
+	ot.meta = &metatable{
+		name: fmt.Sprintf("go-luajit.object.%d", len(objectTypes.m)),
+		init: ot.initMeta,
+	}
+
+	if t.Elem().Kind() == reflect.Struct {
+		for _, f := range cachedFields(t.Elem()) {
+			ot.fields[f.name] = f
+		}
+	}
+
+	for i := range t.NumMethod() {
+		m := t.Method(i)
+		if c, err := reflectCallable(m.Name, m.Func, ot, true); err == nil {
+			ot.methods[m.Name] = c
+		}
+	}
+
+	for event, name := range metamethodNames(t) {
+		if c, ok := ot.methods[name]; ok {
+			m, _ := t.MethodByName(name)
+			ot.metamethods[event] = binaryMetamethod(event, c, ot, m.Func.Type())
+		}
+	}
+
+	objectTypes.m[t] = ot
+	return ot
+}
+
+// objectMetaOf returns the metatable of the objects of the type of v, or nil if none were pushed.
+func objectMetaOf(v any) *metatable {
+	objectTypes.Lock()
+	defer objectTypes.Unlock()
+
+	if ot, ok := objectTypes.m[reflect.TypeOf(v)]; ok {
+		return ot.meta
+	}
+
+	return nil
+}
+
+// pushObject pushes the userdata for the pointer v, creating it if needed.
+func pushObject(L lua.State, v reflect.Value) {
+	ot := objectTypeOf(v.Type())
+
+	lua.CheckStack(L, 5)
+	ot.meta.push(L)                       // mt
+	lua.GetField(L, -1, "__cache")        // mt cache
+	lua.PushLightUserdata(L, v.Pointer()) // mt cache p
+	lua.RawGet(L, -2)                     // mt cache ud
+	if lua.IsNil(L, -1) {
+		lua.Pop(L, 1)                         // mt cache
+		pushHandle(L, v.Interface(), ot.meta) // mt cache ud
+		lua.PushLightUserdata(L, v.Pointer()) // mt cache ud p
+		lua.PushValue(L, -2)                  // mt cache ud p ud
+		lua.RawSet(L, -4)                     // mt cache ud
+	}
+
+	lua.Replace(L, -3) // ud cache
+	lua.Pop(L, 1)      // ud
+}
+
+func (ot *objectType) initMeta(L lua.State) {
+	lua.CreateTable(L, 0, len(ot.methods))
+	for name, c := range ot.methods {
+		pushCallable(L, c)
+		lua.SetField(L, -2, name)
+	}
+	lua.SetField(L, -2, "__methods")
+
+	// Userdata are cached weakly, keyed by the pointer they represent.
+	lua.NewTable(L)
+	lua.CreateTable(L, 0, 1)
+	lua.PushString(L, "v")
+	lua.SetField(L, -2, "__mode")
+	lua.SetMetatable(L, -2)
+	lua.SetField(L, -2, "__cache")
+
+	pushCallable(L, ot.index)
+	lua.SetField(L, -2, "__index")
+
+	pushCallable(L, ot.newIndex)
+	lua.SetField(L, -2, "__newindex")
+
+	pushCallable(L, ot.toString)
+	lua.SetField(L, -2, "__tostring")
+
+	pushCallable(L, ot.equal)
+	lua.SetField(L, -2, "__eq")
+
+	for event, c := range ot.metamethods {
+		pushCallable(L, c)
+		lua.SetField(L, -2, event)
+	}
+}
+
+func (ot *objectType) pointerType() reflect.Type {
+	return ot.typ
+}
+
+func (ot *objectType) self(L lua.State, idx int) (reflect.Value, bool) {
+	self := reflect.ValueOf(toHandle(L, idx))
+	return self, self.IsValid() && self.Type() == ot.typ
+}
+
+func (ot *objectType) push(L lua.State, v reflect.Value) {
+	pushObject(L, v)
+}
+
+// checkSelf returns the pointer represented by the userdata at idx, failing if it isn't one.
+func (ot *objectType) checkSelf(L lua.State, idx int) (reflect.Value, error) {
+	self, ok := ot.self(L, idx)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("luajit: expected %s, got %s", ot.typ, lua.TypeNameOf(L, idx))
+	}
+
+	return self, nil
+}
+
+// index is the __index metamethod of objects.
+func (ot *objectType) index(L lua.State) (int, error) {
+	self, err := ot.checkSelf(L, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	if lua.Type(L, 2) != lua.TString {
+		lua.PushNil(L)
+		return 1, nil
+	}
+
+	key := lua.ToString(L, 2)
+	if f, ok := ot.fields[key]; ok {
+		fv, ok := fieldByIndex(self.Elem(), f.index, false)
+		if !ok {
+			lua.PushNil(L)
+			return 1, nil
+		}
+
+		return 1, pushField(L, fv)
+	}
+
+	lua.GetMetatable(L, 1)
+	lua.GetField(L, -1, "__methods")
+	lua.GetField(L, -1, key)
+	lua.Replace(L, -3)
+	lua.Pop(L, 1)
+	return 1, nil
+}
+
+// newIndex is the __newindex metamethod of objects.
+func (ot *objectType) newIndex(L lua.State) (int, error) {
+	self, err := ot.checkSelf(L, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	key := lua.ToString(L, 2)
+	f, ok := ot.fields[key]
+	if lua.Type(L, 2) != lua.TString || !ok {
+		return 0, &fieldError{Type: ot.typ.Elem(), Field: key, Err: errNoField}
+	}
+
+	fv, ok := fieldByIndex(self.Elem(), f.index, true)
+	if !ok {
+		return 0, &fieldError{Type: ot.typ.Elem(), Field: key, Err: errNoField}
+	}
+
+	// Decode into a copy so a failed conversion leaves the field unchanged.
+	v := reflect.New(fv.Type()).Elem()
+	if err := decode(L, 3, v); err != nil {
+		return 0, &fieldError{Type: ot.typ.Elem(), Field: key, Err: err}
+	}
+
+	fv.Set(v)
+	return 0, nil
+}
+
+// toString is the __tostring metamethod of objects.
+func (ot *objectType) toString(L lua.State) (int, error) {
+	self, err := ot.checkSelf(L, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	lua.PushString(L, fmt.Sprintf("%s: %p", ot.typ.Elem(), self.Interface()))
+	return 1, nil
+}
+
+// equal is the __eq metamethod of objects.
+func (ot *objectType) equal(L lua.State) (int, error) {
+	lua.PushBoolean(L, toHandle(L, 1) == toHandle(L, 2))
+	return 1, nil
+}
+
+// pushField pushes the value of a field of an object.
+//
+// Structs are pushed as objects so that changes to them are visible to Go.
+func pushField(L lua.State, fv reflect.Value) error {
+	switch {
+	case fv.Kind() == reflect.Struct && !isText(fv.Type()):
+		pushObject(L, fv.Addr())
+	case fv.Kind() == reflect.Pointer && fv.Type().Elem().Kind() == reflect.Struct && !fv.IsNil() && !isText(fv.Type()):
+		pushObject(L, fv)
+	default:
+		return encode(L, fv)
+	}
+
+	return nil
+}
+
+// isText returns if values of type t are converted as text.
+func isText(t reflect.Type) bool {
+	return t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)
+}
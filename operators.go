@@ -0,0 +1,150 @@
+package luajit
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// operatorMethods maps the names of conventional Go methods to the metamethods they implement.
+var operatorMethods = map[string]string{
+	"Add":    "__add",
+	"Sub":    "__sub",
+	"Mul":    "__mul",
+	"Div":    "__div",
+	"Mod":    "__mod",
+	"Neg":    "__unm",
+	"Less":   "__lt",
+	"LessEq": "__le",
+	"Equal":  "__eq",
+	"Len":    "__len",
+	"Concat": "__concat",
+	"String": "__tostring",
+	"Call":   "__call",
+}
+
+// metamethodEvents are the events that can be implemented by methods.
+var metamethodEvents = map[string]bool{
+	"__add":      true,
+	"__sub":      true,
+	"__mul":      true,
+	"__div":      true,
+	"__mod":      true,
+	"__pow":      true,
+	"__unm":      true,
+	"__lt":       true,
+	"__le":       true,
+	"__eq":       true,
+	"__len":      true,
+	"__concat":   true,
+	"__tostring": true,
+	"__call":     true,
+}
+
+// binaryEvents are the metamethods of binary operators that can apply to an object and another value.
+var binaryEvents = map[string]bool{
+	"__add":    true,
+	"__sub":    true,
+	"__mul":    true,
+	"__div":    true,
+	"__mod":    true,
+	"__pow":    true,
+	"__concat": true,
+}
+
+// MapMetamethod makes the method of *T with the given name implement the Lua metamethod event
+// for objects of type *T (see State.PushObject).
+//
+// By default, methods are mapped by name:
+//
+//	Add    __add       Neg     __unm       Len     __len
+//	Sub    __sub       Less    __lt        Concat  __concat
+//	Mul    __mul       LessEq  __le        String  __tostring
+//	Div    __div       Equal   __eq        Call    __call
+//	Mod    __mod
+//
+// For binary operators, the receiver is the operand that is an object of type *T, so both
+// 'v * 2' and '2 * v' call v.Mul(2). Methods taking a bool after the other operand, such as
+// Sub(x float64, right bool), are told whether the receiver is the right operand,
+// so '2 - v' calls v.Sub(2, true). Comparisons only apply to two objects.
+//
+// An empty method name removes the mapping for the event.
+//
+// Mappings must be made before the first object of type *T is pushed.
+func MapMetamethod[T any](event, method string) error {
+	t := reflect.TypeFor[*T]()
+	if !metamethodEvents[event] {
+		return fmt.Errorf("luajit: %q is not a metamethod that can be mapped", event)
+	}
+
+	if _, ok := t.MethodByName(method); method != "" && !ok {
+		return fmt.Errorf("luajit: %s has no method %q", t, method)
+	}
+
+	objectTypes.Lock()
+	defer objectTypes.Unlock()
+
+	if _, ok := objectTypes.m[t]; ok {
+		return fmt.Errorf("luajit: metamethods of %s must be mapped before it is pushed", t)
+	}
+
+	if objectTypes.mappings == nil {
+		objectTypes.mappings = make(map[reflect.Type]map[string]string)
+	}
+
+	if objectTypes.mappings[t] == nil {
+		objectTypes.mappings[t] = make(map[string]string)
+	}
+
+	objectTypes.mappings[t][event] = method
+	return nil
+}
+
+// binaryMetamethod wraps the metamethod c implementing the binary operator event with the method expression fn,
+// so that its receiver is the operand represented by recv, whichever it is.
+// If fn takes a bool last, it receives whether the receiver is the right operand.
+func binaryMetamethod(event string, c callable, recv receiver, fn reflect.Type) callable {
+	if !binaryEvents[event] {
+		return c
+	}
+
+	n := fn.NumIn()
+	right := n >= 3 && !fn.IsVariadic() && fn.In(n-1).Kind() == reflect.Bool
+	return func(L lua.State) (int, error) {
+		lua.SetTop(L, 2)
+		_, left := recv.self(L, 1)
+		if !left {
+			lua.Insert(L, 1)
+		}
+
+		if right {
+			lua.PushBoolean(L, !left)
+		}
+
+		return c(L)
+	}
+}
+
+// metamethodNames returns the method implementing each metamethod of the object type t.
+//
+// objectTypes must be locked.
+func metamethodNames(t reflect.Type) map[string]string {
+	names := make(map[string]string)
+	for method, event := range operatorMethods {
+		if _, ok := t.MethodByName(method); ok {
+			names[event] = method
+		}
+	}
+
+	for event, method := range objectTypes.mappings[t] {
+		if method == "" {
+			delete(names, event)
+			continue
+		}
+
+		names[event] = method
+	}
+
+	return names
+}
@@ -0,0 +1,534 @@
+package luajit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// persistHeader starts the data created by Persist, followed by its version.
+const (
+	persistHeader  = "\x1bGLP"
+	persistVersion = 1
+)
+
+// The tags preceding each value in persisted data.
+const (
+	tagNil byte = iota
+	tagFalse
+	tagTrue
+	tagNumber    // float64 bits
+	tagString    // Length and bytes
+	tagTable     // Key and value pairs until a nil key, then the metatable
+	tagFunction  // Bytecode length and bytes, number of upvalues, then each upvalue
+	tagReference // ID of a table or function persisted before
+	tagPermanent // Name in the permanents table
+)
+
+// The forms of upvalues in persisted data.
+const (
+	upvalueValue  byte = iota // The value of the upvalue
+	upvalueShared             // The ID of a function persisted before and the index of its upvalue
+)
+
+var errCorrupt = errors.New("luajit: corrupt persisted data")
+
+// PersistOption configures Persist and Unpersist.
+type PersistOption func(*persistConfig)
+
+type persistConfig struct {
+	perms *Table
+}
+
+// WithPermanents persists the keys of perms as their value, a string naming them, instead of their contents.
+//
+// This is required for values that cannot be persisted, such as C functions, Go functions and userdata,
+// and useful for the ones that shouldn't be copied, such as standard libraries.
+// Unpersist must be given a table with the same names, which it maps back to the values.
+func WithPermanents(perms *Table) PersistOption {
+	return func(c *persistConfig) {
+		c.perms = perms
+	}
+}
+
+// Persist serializes the value at idx, which Unpersist restores.
+//
+// nil, booleans, numbers, strings, tables and Lua functions can be persisted.
+// Tables keep their metatables, and tables or functions referenced more than once, including through cycles,
+// are restored once and shared.
+// Functions are persisted as bytecode along with their upvalues. Closures sharing an upvalue share it again
+// once restored. Their environment is not persisted: restored functions use the globals of the state.
+// Other values must be permanents (see WithPermanents).
+func Persist(s State, idx int, opts ...PersistOption) ([]byte, error) {
+	var c persistConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	L := s.thread()
+	if idx < 0 && idx > lua.RegistryIndex {
+		idx = lua.GetTop(L) + idx + 1
+	}
+
+	top := lua.GetTop(L)
+	defer lua.SetTop(L, top)
+
+	p := &persister{
+		L:      L,
+		buf:    append([]byte(persistHeader), persistVersion),
+		ids:    make(map[uintptr]int),
+		upvals: make(map[uintptr]upvalue),
+	}
+
+	if c.perms != nil {
+		if err := c.perms.ref.pushTo(L); err != nil {
+			return nil, err
+		}
+		p.perms = lua.GetTop(L)
+	}
+
+	if err := p.persist(idx); err != nil {
+		return nil, err
+	}
+
+	return p.buf, nil
+}
+
+// Unpersist pushes the value serialized by Persist onto the stack.
+//
+// Functions are loaded from the bytecode in data, which can crash the process if it's malformed,
+// so data must come from a trusted source.
+func Unpersist(s State, data []byte, opts ...PersistOption) error {
+	var c persistConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if len(data) < len(persistHeader)+1 || string(data[:len(persistHeader)]) != persistHeader {
+		return errCorrupt
+	}
+
+	if v := data[len(persistHeader)]; v != persistVersion {
+		return fmt.Errorf("luajit: unsupported persisted data version %d", v)
+	}
+
+	L := s.thread()
+	top := lua.GetTop(L)
+	lua.CheckStack(L, 3)
+
+	u := &unpersister{L: L, data: data[len(persistHeader)+1:]}
+	lua.NewTable(L)
+	u.cache = lua.GetTop(L)
+
+	// The permanents are looked up by name.
+	lua.NewTable(L)
+	u.perms = lua.GetTop(L)
+	if c.perms != nil {
+		if err := c.perms.ref.pushTo(L); err != nil {
+			lua.SetTop(L, top)
+			return err
+		}
+
+		lua.ForEach(L, -1, func(k, v int) bool {
+			lua.PushValue(L, v)
+			lua.PushValue(L, k)
+			lua.RawSet(L, u.perms)
+			return true
+		})
+		lua.Pop(L, 1)
+	}
+
+	if err := u.unpersist(); err != nil {
+		lua.SetTop(L, top)
+		return err
+	}
+
+	if len(u.data) > 0 {
+		lua.SetTop(L, top)
+		return errCorrupt
+	}
+
+	lua.Replace(L, top+1)
+	lua.SetTop(L, top+1)
+	return nil
+}
+
+// upvalue identifies an upvalue by the ID of a function using it and its index.
+type upvalue struct {
+	fn, n int
+}
+
+type persister struct {
+	L      lua.State
+	buf    []byte
+	perms  int                 // The index of the permanents table, or 0
+	ids    map[uintptr]int     // The IDs of the tables and functions persisted, by address
+	upvals map[uintptr]upvalue // The upvalues persisted, by their lua.UpvalueID
+}
+
+func (p *persister) persist(idx int) error {
+	L := p.L
+	if lua.CheckStack(L, 4) == 0 {
+		return errors.New("luajit: value too deeply nested to persist")
+	}
+
+	t := lua.Type(L, idx)
+	switch t {
+	case lua.TNone, lua.TNil:
+		p.buf = append(p.buf, tagNil)
+		return nil
+	case lua.TBoolean:
+		if lua.ToBoolean(L, idx) {
+			p.buf = append(p.buf, tagTrue)
+		} else {
+			p.buf = append(p.buf, tagFalse)
+		}
+		return nil
+	case lua.TNumber:
+		p.buf = append(p.buf, tagNumber)
+		p.buf = binary.LittleEndian.AppendUint64(p.buf, math.Float64bits(float64(lua.ToNumber(L, idx))))
+		return nil
+	case lua.TString:
+		p.buf = append(p.buf, tagString)
+		p.appendBytes(lua.ToBytes(L, idx))
+		return nil
+	}
+
+	if p.perms != 0 {
+		lua.PushValue(L, idx)
+		lua.RawGet(L, p.perms)
+		name, ok := lua.ToBytes(L, -1), lua.Type(L, -1) == lua.TString
+		lua.Pop(L, 1)
+		if ok {
+			p.buf = append(p.buf, tagPermanent)
+			p.appendBytes(name)
+			return nil
+		}
+	}
+
+	addr := lua.ToPointer(L, idx)
+	if id, ok := p.ids[addr]; ok {
+		p.buf = append(p.buf, tagReference)
+		p.buf = binary.AppendUvarint(p.buf, uint64(id))
+		return nil
+	}
+
+	switch {
+	case t == lua.TTable:
+		p.ids[addr] = len(p.ids) + 1
+		return p.persistTable(idx)
+	case t == lua.TFunction && !lua.IsCFunction(L, idx):
+		id := len(p.ids) + 1
+		p.ids[addr] = id
+		return p.persistFunction(idx, id)
+	}
+
+	return fmt.Errorf("luajit: cannot persist %s %#x, which must be a permanent", lua.TypeNameOf(L, idx), addr)
+}
+
+func (p *persister) persistTable(idx int) error {
+	L := p.L
+	p.buf = append(p.buf, tagTable)
+
+	var err error
+	lua.ForEach(L, idx, func(k, v int) bool {
+		if err = p.persist(k); err == nil {
+			err = p.persist(v)
+		}
+
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.buf = append(p.buf, tagNil)
+	if !lua.GetMetatable(L, idx) {
+		p.buf = append(p.buf, tagNil)
+		return nil
+	}
+
+	defer lua.Pop(L, 1)
+	return p.persist(lua.GetTop(L))
+}
+
+func (p *persister) persistFunction(idx, id int) error {
+	L := p.L
+	lua.PushValue(L, idx)
+	code, status := lua.Dump(L)
+	lua.Pop(L, 1)
+	if status != 0 {
+		return errors.New("luajit: unable to dump function")
+	}
+
+	p.buf = append(p.buf, tagFunction)
+	p.appendBytes(code)
+
+	n := 0
+	for {
+		if _, ok := lua.GetUpvalue(L, idx, n+1); !ok {
+			break
+		}
+		lua.Pop(L, 1)
+		n++
+	}
+
+	p.buf = binary.AppendUvarint(p.buf, uint64(n))
+	for i := 1; i <= n; i++ {
+		uid := lua.UpvalueID(L, idx, i)
+		if uv, ok := p.upvals[uid]; ok {
+			p.buf = append(p.buf, upvalueShared)
+			p.buf = binary.AppendUvarint(p.buf, uint64(uv.fn))
+			p.buf = binary.AppendUvarint(p.buf, uint64(uv.n))
+			continue
+		}
+
+		// Recorded first, so that functions reached from the value share it.
+		p.upvals[uid] = upvalue{id, i}
+		p.buf = append(p.buf, upvalueValue)
+
+		lua.GetUpvalue(L, idx, i)
+		err := p.persist(lua.GetTop(L))
+		lua.Pop(L, 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *persister) appendBytes(b []byte) {
+	p.buf = binary.AppendUvarint(p.buf, uint64(len(b)))
+	p.buf = append(p.buf, b...)
+}
+
+type unpersister struct {
+	L     lua.State
+	data  []byte
+	cache int // The index of the table holding the tables and functions restored, by ID
+	perms int // The index of the table mapping the names of permanents to them
+	n     int // The number of tables and functions restored
+}
+
+// unpersist pushes the next value.
+func (u *unpersister) unpersist() error {
+	L := u.L
+	if lua.CheckStack(L, 4) == 0 {
+		return errors.New("luajit: value too deeply nested to unpersist")
+	}
+
+	tag, err := u.byte()
+	if err != nil {
+		return err
+	}
+
+	switch tag {
+	case tagNil:
+		lua.PushNil(L)
+	case tagFalse, tagTrue:
+		lua.PushBoolean(L, tag == tagTrue)
+	case tagNumber:
+		if len(u.data) < 8 {
+			return errCorrupt
+		}
+		lua.PushNumber(L, lua.Number(math.Float64frombits(binary.LittleEndian.Uint64(u.data))))
+		u.data = u.data[8:]
+	case tagString:
+		b, err := u.bytes()
+		if err != nil {
+			return err
+		}
+		lua.PushLString(L, string(b), len(b))
+	case tagReference:
+		id, err := u.uvarint()
+		if err != nil {
+			return err
+		}
+		lua.RawGetI(L, u.cache, int(id))
+		if lua.IsNil(L, -1) {
+			lua.Pop(L, 1)
+			return errCorrupt
+		}
+	case tagPermanent:
+		name, err := u.bytes()
+		if err != nil {
+			return err
+		}
+		lua.PushLString(L, string(name), len(name))
+		lua.RawGet(L, u.perms)
+		if lua.IsNil(L, -1) {
+			lua.Pop(L, 1)
+			return fmt.Errorf("luajit: missing permanent %q", name)
+		}
+	case tagTable:
+		return u.unpersistTable()
+	case tagFunction:
+		return u.unpersistFunction()
+	default:
+		return errCorrupt
+	}
+
+	return nil
+}
+
+func (u *unpersister) unpersistTable() error {
+	L := u.L
+	lua.NewTable(L)
+	t := lua.GetTop(L)
+	u.remember()
+
+	for {
+		if err := u.unpersist(); err != nil {
+			return err
+		}
+
+		if lua.IsNil(L, -1) {
+			lua.Pop(L, 1)
+			break
+		}
+
+		if lua.Type(L, -1) == lua.TNumber && math.IsNaN(float64(lua.ToNumber(L, -1))) {
+			return errCorrupt
+		}
+
+		if err := u.unpersist(); err != nil {
+			return err
+		}
+
+		lua.RawSet(L, t)
+	}
+
+	if err := u.unpersist(); err != nil {
+		return err
+	}
+
+	switch lua.Type(L, -1) {
+	case lua.TNil:
+		lua.Pop(L, 1)
+	case lua.TTable:
+		lua.SetMetatable(L, t)
+	default:
+		return errCorrupt
+	}
+
+	return nil
+}
+
+func (u *unpersister) unpersistFunction() error {
+	L := u.L
+	code, err := u.bytes()
+	if err != nil {
+		return err
+	}
+
+	if status := lua.LoadBuffer(L, code, "=unpersist"); status != lua.StatusOk {
+		return popError(L, status)
+	}
+
+	f := lua.GetTop(L)
+	u.remember()
+
+	n, err := u.uvarint()
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= int(n); i++ {
+		form, err := u.byte()
+		if err != nil {
+			return err
+		}
+
+		switch form {
+		case upvalueValue:
+			if err := u.unpersist(); err != nil {
+				return err
+			}
+
+			if _, ok := lua.SetUpvalue(L, f, i); !ok {
+				lua.Pop(L, 1)
+				return errCorrupt
+			}
+		case upvalueShared:
+			id, err := u.uvarint()
+			if err != nil {
+				return err
+			}
+
+			m, err := u.uvarint()
+			if err != nil {
+				return err
+			}
+
+			if _, ok := lua.GetUpvalue(L, f, i); !ok {
+				return errCorrupt
+			}
+			lua.Pop(L, 1)
+
+			lua.RawGetI(L, u.cache, int(id))
+			if !lua.IsFunction(L, -1) || lua.IsCFunction(L, -1) {
+				lua.Pop(L, 1)
+				return errCorrupt
+			}
+
+			if _, ok := lua.GetUpvalue(L, -1, int(m)); !ok {
+				lua.Pop(L, 1)
+				return errCorrupt
+			}
+			lua.Pop(L, 1)
+
+			lua.UpvalueJoin(L, f, i, lua.GetTop(L), int(m))
+			lua.Pop(L, 1)
+		default:
+			return errCorrupt
+		}
+	}
+
+	return nil
+}
+
+// remember records the value at the top of the stack as the next table or function restored.
+func (u *unpersister) remember() {
+	u.n++
+	lua.PushValue(u.L, -1)
+	lua.RawSetI(u.L, u.cache, u.n)
+}
+
+func (u *unpersister) byte() (byte, error) {
+	if len(u.data) == 0 {
+		return 0, errCorrupt
+	}
+
+	b := u.data[0]
+	u.data = u.data[1:]
+	return b, nil
+}
+
+func (u *unpersister) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(u.data)
+	if n <= 0 || v > math.MaxInt32 {
+		return 0, errCorrupt
+	}
+
+	u.data = u.data[n:]
+	return v, nil
+}
+
+func (u *unpersister) bytes() ([]byte, error) {
+	n, err := u.uvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(u.data)) < n {
+		return nil, errCorrupt
+	}
+
+	b := u.data[:n]
+	u.data = u.data[n:]
+	return b, nil
+}
@@ -0,0 +1,112 @@
+package luajit
+
+import (
+	"testing"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+func TestPersistRoundTrip(t *testing.T) {
+	s := newTestState(t)
+	if err := s.DoString(`
+		local count = 10
+		counter = {
+			inc = function() count = count + 1 return count end,
+			get = function() return count end,
+		}
+		counter.self = counter
+		counter.alias = counter.inc
+		setmetatable(counter, {__index = {kind = "counter"}})
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.GetGlobal("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Marshal(s, v); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Persist(s, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lua.Pop(s.thread(), 1)
+
+	restored := newTestState(t)
+	if err := Unpersist(restored, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := setGlobal(restored.thread(), "counter"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restored.DoString(`
+		assert(counter.self == counter, "cycle not kept")
+		assert(counter.alias == counter.inc, "shared function restored twice")
+		assert(counter.kind == "counter", "metatable not restored")
+		assert(counter.get() == 10, "upvalue not restored")
+		counter.inc()
+		assert(counter.get() == 11, "upvalue not shared")
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPersistPermanents(t *testing.T) {
+	s := newTestState(t)
+	if err := s.DoString(`value = {print = print}`); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.GetGlobal("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Marshal(s, v); err != nil {
+		t.Fatal(err)
+	}
+	defer lua.Pop(s.thread(), 1)
+
+	if _, err := Persist(s, -1); err == nil {
+		t.Error("persisted a C function that isn't a permanent")
+	}
+
+	perms := s.NewTable()
+	print, err := s.GetGlobal("print")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.Set(print, "print"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Persist(s, -1, WithPermanents(perms))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestState(t)
+	print, err = restored.GetGlobal("print")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	perms = restored.NewTable()
+	if err := perms.Set("print", print); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Unpersist(restored, data, WithPermanents(perms)); err != nil {
+		t.Fatal(err)
+	}
+	if err := setGlobal(restored.thread(), "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restored.DoString(`assert(value.print == print, "permanent not restored")`); err != nil {
+		t.Fatal(err)
+	}
+}
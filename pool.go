@@ -0,0 +1,416 @@
+package luajit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// ErrPoolClosed is returned by Pool.Get once the pool is closed.
+var ErrPoolClosed = errors.New("luajit: pool closed")
+
+// PoolOption configures a Pool.
+type PoolOption func(*Pool)
+
+// WithMaxSize limits the number of states of the pool, idle or in use, to n.
+// Pool.Get waits for a state to be put back once the limit is reached.
+func WithMaxSize(n int) PoolOption {
+	return func(p *Pool) {
+		p.maxSize = n
+	}
+}
+
+// WithIdleTimeout closes the states that stay idle for longer than d.
+func WithIdleTimeout(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.idleTimeout = d
+	}
+}
+
+// WithMemoryLimit discards the states using more than n bytes once they're put back and collected.
+func WithMemoryLimit(n int) PoolOption {
+	return func(p *Pool) {
+		p.memoryLimit = n
+	}
+}
+
+// WithStateFactory creates the states of the pool with fn, such as a function calling NewSandbox,
+// instead of NewState and State.OpenLibs.
+func WithStateFactory(fn func() (State, error)) PoolOption {
+	return func(p *Pool) {
+		p.factory = fn
+	}
+}
+
+// WithResetHook calls fn on the states put back, after their globals are restored.
+// The state is discarded if fn fails.
+func WithResetHook(fn func(s State) error) PoolOption {
+	return func(p *Pool) {
+		p.resets = append(p.resets, fn)
+	}
+}
+
+// WithHealthCheck calls fn on the states put back once they're reset.
+// The state is discarded if fn fails.
+func WithHealthCheck(fn func(s State) error) PoolOption {
+	return func(p *Pool) {
+		p.checks = append(p.checks, fn)
+	}
+}
+
+// Pool keeps initialized states for reuse, as creating and initializing one for each use is slow.
+//
+// Each state is used by one goroutine at a time: Get hands it out, and Put gives it back.
+// Get makes the calling goroutine the owner of the state, see State.Adopt.
+// A state put back is reset: its stack is cleared, its globals are restored to what they were
+// after initialization, and a full garbage collection runs.
+// Only the globals themselves are restored, not the contents of the tables they hold.
+//
+// A Pool is safe for concurrent use.
+type Pool struct {
+	init        func(s State) error
+	factory     func() (State, error)
+	resets      []func(s State) error
+	checks      []func(s State) error
+	maxSize     int
+	idleTimeout time.Duration
+	memoryLimit int
+
+	slots chan struct{} // Holds a value for each state in use, if the size is limited
+
+	mu     sync.Mutex
+	idle   []*pooled // Most recently used last
+	states map[lua.State]*pooled
+	closed bool
+	stop   chan struct{}
+}
+
+// pooled is a state created by a pool.
+type pooled struct {
+	s         State
+	snapshot  *reference // A copy of the globals after initialization
+	idleSince time.Time
+	inUse     bool // Whether Get handed the state out, and it wasn't put back since
+}
+
+// NewPool creates a pool of states initialized with init, which may be nil.
+//
+// init typically registers functions and loads the code shared by every use of the states.
+func NewPool(init func(s State) error, opts ...PoolOption) *Pool {
+	p := &Pool{
+		init:   init,
+		states: make(map[lua.State]*pooled),
+		stop:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.maxSize > 0 {
+		p.slots = make(chan struct{}, p.maxSize)
+	}
+
+	if p.idleTimeout > 0 {
+		go p.evictLoop()
+	}
+
+	return p
+}
+
+// Get returns an idle state, or a new one if there is none.
+//
+// If the pool is at its maximum size, Get waits until a state is put back or ctx is done.
+func (p *Pool) Get(ctx context.Context) (State, error) {
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.release()
+		return 0, ErrPoolClosed
+	}
+
+	if n := len(p.idle); n > 0 {
+		ps := p.idle[n-1]
+		p.idle[n-1] = nil
+		p.idle = p.idle[:n-1]
+		ps.inUse = true
+		p.mu.Unlock()
+		ps.s.Adopt()
+		return ps.s, nil
+	}
+	p.mu.Unlock()
+
+	ps, err := p.create()
+	if err != nil {
+		p.release()
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		ps.s.Close()
+		p.release()
+		return 0, ErrPoolClosed
+	}
+
+	ps.inUse = true
+	p.states[lua.State(ps.s)] = ps
+	return ps.s, nil
+}
+
+// Put resets s and makes it available to Get again.
+//
+// s must come from Get, and must not be used afterwards. Put panics if s was already put back.
+// If resetting s fails or it's unhealthy, it's closed instead.
+// Use Discard for the states left in an unknown condition, such as after an error.
+func (p *Pool) Put(s State) {
+	ps := p.take(s)
+	if err := p.reset(ps); err != nil {
+		p.discard(ps)
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.discard(ps)
+		return
+	}
+
+	ps.idleSince = time.Now()
+	p.idle = append(p.idle, ps)
+	p.mu.Unlock()
+	p.release()
+}
+
+// Discard closes s, which must come from Get, making room for a new state.
+func (p *Pool) Discard(s State) {
+	p.discard(p.take(s))
+}
+
+// discard closes a state taken back from its user.
+func (p *Pool) discard(ps *pooled) {
+	p.mu.Lock()
+	delete(p.states, lua.State(ps.s))
+	p.mu.Unlock()
+
+	ps.s.Close()
+	p.release()
+}
+
+// Len returns the number of states of the pool, idle or in use.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.states)
+}
+
+// Close closes the idle states, and the states in use once they're put back.
+// Get fails with ErrPoolClosed afterwards.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+
+	p.closed = true
+	close(p.stop)
+	idle := p.idle
+	p.idle = nil
+	for _, ps := range idle {
+		delete(p.states, lua.State(ps.s))
+	}
+	p.mu.Unlock()
+
+	for _, ps := range idle {
+		ps.s.Adopt()
+		ps.s.Close()
+	}
+}
+
+// take returns the pool's record of s and marks it as no longer in use,
+// panicking if s doesn't come from the pool or was already put back.
+func (p *Pool) take(s State) *pooled {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ps := p.states[lua.State(s)]
+	if ps == nil {
+		panic("luajit: state not from the pool")
+	}
+	if !ps.inUse {
+		panic("luajit: state put back twice")
+	}
+
+	ps.inUse = false
+	return ps
+}
+
+// release frees the slot of a state that's no longer in use.
+func (p *Pool) release() {
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+// create creates and initializes a state, taking a snapshot of its globals.
+func (p *Pool) create() (*pooled, error) {
+	var s State
+	if p.factory != nil {
+		var err error
+		if s, err = p.factory(); err != nil {
+			return nil, err
+		}
+	} else {
+		s = NewState()
+		s.OpenLibs()
+	}
+
+	if p.init != nil {
+		if err := p.init(s); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("luajit: unable to initialize pooled state: %w", err)
+		}
+	}
+
+	L := s.data().main
+	lua.SetTop(L, 0)
+	lua.NewTable(L)
+	lua.ForEach(L, lua.GlobalsIndex, func(k, v int) bool {
+		lua.PushValue(L, k)
+		lua.PushValue(L, v)
+		lua.RawSet(L, 1)
+		return true
+	})
+
+	return &pooled{s: s, snapshot: popReference(L)}, nil
+}
+
+// reset restores the state of ps to its snapshot and checks its health.
+func (p *Pool) reset(ps *pooled) error {
+	d := ps.s.data()
+	if d.current != d.main || d.limited > 0 {
+		return errors.New("luajit: pooled state put back while running")
+	}
+
+	if d.sched != nil {
+		d.sched.Close()
+	}
+
+	L := d.main
+	lua.SetTop(L, 0)
+	restoreGlobals(L, ps.snapshot)
+
+	for _, fn := range p.resets {
+		if err := fn(ps.s); err != nil {
+			return err
+		}
+	}
+
+	lua.SetTop(L, 0)
+	lua.GC(L, lua.GCCollect, 0)
+	if p.memoryLimit > 0 && lua.GetGCCount(L)*1024 > p.memoryLimit {
+		return errors.New("luajit: pooled state over its memory limit")
+	}
+
+	for _, fn := range p.checks {
+		if err := fn(ps.s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreGlobals makes the globals equal to the fields of the snapshot table.
+func restoreGlobals(L lua.State, snapshot *reference) {
+	base := lua.GetTop(L)
+	defer lua.SetTop(L, base)
+
+	lua.CheckStack(L, 6)
+	g, snap, added := base+1, base+2, base+3
+	lua.PushValue(L, lua.GlobalsIndex)
+	snapshot.push(L)
+	lua.NewTable(L)
+
+	// Keys cannot be removed during the traversal, so they're listed first.
+	n := 0
+	lua.ForEach(L, g, func(k, v int) bool {
+		lua.PushValue(L, k)
+		lua.RawGet(L, snap)
+		if lua.IsNil(L, -1) {
+			n++
+			lua.PushValue(L, k)
+			lua.RawSetI(L, added, n)
+		}
+
+		return true
+	})
+
+	for i := 1; i <= n; i++ {
+		lua.RawGetI(L, added, i)
+		lua.PushNil(L)
+		lua.RawSet(L, g)
+	}
+
+	lua.ForEach(L, snap, func(k, v int) bool {
+		lua.PushValue(L, k)
+		lua.PushValue(L, v)
+		lua.RawSet(L, g)
+		return true
+	})
+}
+
+// evictLoop closes the states idle for too long, until the pool is closed.
+func (p *Pool) evictLoop() {
+	ticker := time.NewTicker(max(p.idleTimeout/2, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			p.evict(now)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// evict closes the states idle since before now minus the idle timeout.
+func (p *Pool) evict(now time.Time) {
+	p.mu.Lock()
+	var expired []*pooled
+	idle := p.idle[:0]
+	for _, ps := range p.idle {
+		if now.Sub(ps.idleSince) > p.idleTimeout {
+			expired = append(expired, ps)
+			delete(p.states, lua.State(ps.s))
+			continue
+		}
+
+		idle = append(idle, ps)
+	}
+
+	clear(p.idle[len(idle):])
+	p.idle = idle
+	p.mu.Unlock()
+
+	for _, ps := range expired {
+		ps.s.Adopt()
+		ps.s.Close()
+	}
+}
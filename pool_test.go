@@ -0,0 +1,46 @@
+package luajit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+func TestPoolReset(t *testing.T) {
+	if err := lua.LoadError(); err != nil {
+		t.Skipf("luajit not available: %v", err)
+	}
+
+	p := NewPool(func(s State) error {
+		return s.DoString(`counter = 0`)
+	}, WithMaxSize(1))
+	defer p.Close()
+
+	ctx := context.Background()
+	s, err := p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DoString(`counter = counter + 1 leaked = true print = nil`); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(s)
+
+	s, err = p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Put(s)
+
+	if err := s.DoString(`assert(counter == 0, "counter not reset")
+		assert(leaked == nil, "global not removed")
+		assert(print ~= nil, "global not restored")`); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := p.Len(); n != 1 {
+		t.Errorf("got %d states, want the one reused", n)
+	}
+}
@@ -0,0 +1,323 @@
+package luajit
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// ProfileOptions configures StartProfile.
+type ProfileOptions struct {
+	Interval time.Duration // The time between samples, 10ms by default, rounded to milliseconds
+	Depth    int           // The maximum number of frames per sample, 64 by default
+	Mode     string        // "f" to profile functions, or "l" to profile lines, the default
+}
+
+// Profiler collects the samples of LuaJIT's profiler, see StartProfile.
+type Profiler struct {
+	s      State
+	opts   ProfileOptions
+	format string // The format of the stacks dumped, or "" to parse them with lua.ProfileDumpStack
+	depth  int
+	start  time.Time
+
+	mu      sync.Mutex
+	samples map[profileKey]int
+	frames  map[string][]lua.ProfileFrame // Maps the stacks parsed to their frames
+}
+
+// profileKey identifies the samples of a stack taken in a VM state.
+type profileKey struct {
+	stack   string // The dump of the stack, or a key identifying its frames
+	vmstate lua.VMState
+}
+
+// profiling holds the profiler running, as LuaJIT only runs one at a time.
+var profiling struct {
+	sync.Mutex
+	p *Profiler
+}
+
+var profileFunc = sync.OnceValue(func() lua.ProfileFunc {
+	return lua.NewProfileFunc(profileSample)
+})
+
+// StartProfile starts LuaJIT's profiler for s and the coroutines it runs.
+//
+// Samples are aggregated until Profiler.Stop is called, which must happen on the goroutine using s.
+// Only one profiler can run at a time.
+func StartProfile(s State, opts ProfileOptions) (*Profiler, error) {
+	return startProfile(s, opts, "", false)
+}
+
+// startProfile starts a profiler dumping stacks with format, outermost frame first if reverse is true.
+func startProfile(s State, opts ProfileOptions, format string, reverse bool) (*Profiler, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Millisecond
+	}
+	if opts.Depth <= 0 {
+		opts.Depth = 64
+	}
+	switch opts.Mode {
+	case "":
+		opts.Mode = "l"
+	case "f", "l":
+	default:
+		return nil, fmt.Errorf("luajit: invalid profile mode %q", opts.Mode)
+	}
+
+	profiling.Lock()
+	defer profiling.Unlock()
+	if profiling.p != nil {
+		return nil, errors.New("luajit: profiler already running")
+	}
+
+	p := &Profiler{
+		s:       s,
+		opts:    opts,
+		format:  format,
+		depth:   opts.Depth,
+		start:   time.Now(),
+		samples: make(map[profileKey]int),
+		frames:  make(map[string][]lua.ProfileFrame),
+	}
+	if reverse {
+		p.depth = -p.depth
+	}
+	profiling.p = p
+
+	ms := max(opts.Interval.Milliseconds(), 1)
+	lua.ProfileStartFunc(s.thread(), opts.Mode+"i"+strconv.FormatInt(ms, 10), profileFunc(), 0)
+	return p, nil
+}
+
+// profileSample is the callback of LuaJIT's profiler, called by the VM between instructions.
+func profileSample(_ uintptr, L lua.State, samples, vmstate int32) {
+	profiling.Lock()
+	p := profiling.p
+	profiling.Unlock()
+	if p == nil {
+		return
+	}
+
+	var (
+		stack  string
+		frames []lua.ProfileFrame
+	)
+	if p.format != "" {
+		stack = string(lua.ProfileDumpStackBytes(L, p.format, p.depth))
+	} else {
+		frames = lua.ProfileDumpStack(L, p.depth)
+		stack = profileStackKey(frames)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples[profileKey{stack: stack, vmstate: lua.VMState(vmstate)}] += int(samples)
+	if frames != nil && p.frames[stack] == nil {
+		p.frames[stack] = frames
+	}
+}
+
+// profileStackKey returns a string identifying frames.
+func profileStackKey(frames []lua.ProfileFrame) string {
+	var b strings.Builder
+	for _, f := range frames {
+		b.WriteString(f.Module)
+		b.WriteByte(0)
+		b.WriteString(f.Function)
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(f.Line))
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// Stop stops the profiler and returns the samples collected as a pprof profile,
+// as read by go tool pprof.
+//
+// Locations are Lua functions and lines, and samples are labeled with the VM state they were taken in.
+func (p *Profiler) Stop() []byte {
+	p.stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.encode()
+}
+
+// stop stops LuaJIT's profiler, unless it's already stopped.
+func (p *Profiler) stop() {
+	profiling.Lock()
+	defer profiling.Unlock()
+	if profiling.p == p {
+		lua.ProfileStop(p.s.thread())
+		profiling.p = nil
+	}
+}
+
+// encode encodes the samples collected as a pprof profile.
+func (p *Profiler) encode() []byte {
+	var (
+		b         pprofBuilder
+		functions = make(map[[2]string]uint64)
+		locations = make(map[[2]uint64]uint64)
+		body      protoBuffer
+	)
+
+	b.strings = map[string]int64{"": 0}
+	b.table = []string{""}
+
+	keys := make([]profileKey, 0, len(p.samples))
+	for k := range p.samples {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b profileKey) int {
+		if c := strings.Compare(a.stack, b.stack); c != 0 {
+			return c
+		}
+		return int(a.vmstate - b.vmstate)
+	})
+
+	interval := p.opts.Interval.Nanoseconds()
+	body.message(1, func(m *protoBuffer) { // sample_type
+		m.varint(1, uint64(b.str("samples")))
+		m.varint(2, uint64(b.str("count")))
+	})
+	body.message(1, func(m *protoBuffer) {
+		m.varint(1, uint64(b.str("cpu")))
+		m.varint(2, uint64(b.str("nanoseconds")))
+	})
+
+	var defs protoBuffer
+	for _, k := range keys {
+		var ids []uint64
+		for _, f := range p.frames[k.stack] {
+			fk := [2]string{f.Module, f.Function}
+			fid, ok := functions[fk]
+			if !ok {
+				fid = uint64(len(functions) + 1)
+				functions[fk] = fid
+				defs.message(5, func(m *protoBuffer) { // function
+					m.varint(1, fid)
+					m.varint(2, uint64(b.str(f.Function)))
+					m.varint(3, uint64(b.str(f.Function)))
+					m.varint(4, uint64(b.str(f.Module)))
+				})
+			}
+
+			lk := [2]uint64{fid, uint64(f.Line)}
+			lid, ok := locations[lk]
+			if !ok {
+				lid = uint64(len(locations) + 1)
+				locations[lk] = lid
+				defs.message(4, func(m *protoBuffer) { // location
+					m.varint(1, lid)
+					m.message(4, func(l *protoBuffer) {
+						l.varint(1, fid)
+						l.varint(2, uint64(f.Line))
+					})
+				})
+			}
+
+			ids = append(ids, lid)
+		}
+
+		n := int64(p.samples[k])
+		body.message(2, func(m *protoBuffer) { // sample
+			m.packed(1, ids)
+			m.packed(2, []uint64{uint64(n), uint64(n * interval)})
+			m.message(3, func(l *protoBuffer) {
+				l.varint(1, uint64(b.str("vmstate")))
+				l.varint(2, uint64(b.str(k.vmstate.String())))
+			})
+		})
+	}
+
+	body.b = append(body.b, defs.b...)
+
+	periodType := [2]int64{b.str("cpu"), b.str("nanoseconds")}
+	for _, s := range b.table {
+		body.bytes(6, []byte(s)) // string_table
+	}
+
+	body.varint(9, uint64(p.start.UnixNano())) // time_nanos
+	body.varint(10, uint64(time.Since(p.start).Nanoseconds()))
+	body.message(11, func(m *protoBuffer) { // period_type
+		m.varint(1, uint64(periodType[0]))
+		m.varint(2, uint64(periodType[1]))
+	})
+	body.varint(12, uint64(interval))
+
+	return body.b
+}
+
+// pprofBuilder holds the string table of a pprof profile.
+type pprofBuilder struct {
+	strings map[string]int64
+	table   []string
+}
+
+// str returns the index of s in the string table, adding it if needed.
+func (b *pprofBuilder) str(s string) int64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+
+	i := int64(len(b.table))
+	b.strings[s] = i
+	b.table = append(b.table, s)
+	return i
+}
+
+// protoBuffer encodes the protocol buffers wire format, enough for pprof profiles.
+type protoBuffer struct {
+	b []byte
+}
+
+func (pb *protoBuffer) uvarint(v uint64) {
+	for v >= 0x80 {
+		pb.b = append(pb.b, byte(v)|0x80)
+		v >>= 7
+	}
+	pb.b = append(pb.b, byte(v))
+}
+
+// varint appends a varint field, unless v is 0, the default.
+func (pb *protoBuffer) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+
+	pb.uvarint(uint64(field) << 3)
+	pb.uvarint(v)
+}
+
+// bytes appends a length-delimited field.
+func (pb *protoBuffer) bytes(field int, b []byte) {
+	pb.uvarint(uint64(field)<<3 | 2)
+	pb.uvarint(uint64(len(b)))
+	pb.b = append(pb.b, b...)
+}
+
+// packed appends a packed repeated varint field.
+func (pb *protoBuffer) packed(field int, vs []uint64) {
+	var m protoBuffer
+	for _, v := range vs {
+		m.uvarint(v)
+	}
+	pb.bytes(field, m.b)
+}
+
+// message appends a message field, encoded by fn.
+func (pb *protoBuffer) message(field int, fn func(m *protoBuffer)) {
+	var m protoBuffer
+	fn(&m)
+	pb.bytes(field, m.b)
+}
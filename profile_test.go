@@ -0,0 +1,214 @@
+package luajit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// protoField is a field of a protocol buffers message, as read by parseProto.
+type protoField struct {
+	num   int
+	value uint64 // The value of varint fields
+	bytes []byte // The contents of length-delimited fields
+}
+
+// parseProto reads the fields of a message, which may only hold varint and length-delimited fields.
+func parseProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid key")
+		}
+		b = b[n:]
+
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			if f.value, n = binary.Uvarint(b); n <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			b = b[n:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, errors.New("invalid length")
+			}
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return nil, errors.New("unexpected wire type")
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// parsePacked reads a packed repeated varint field.
+func parsePacked(b []byte) []uint64 {
+	var vs []uint64
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil
+		}
+		vs = append(vs, v)
+		b = b[n:]
+	}
+
+	return vs
+}
+
+func TestProfileEncode(t *testing.T) {
+	frames := []lua.ProfileFrame{
+		{Module: "main.lua", Function: "update", Line: 12},
+		{Module: "main.lua", Function: "main", Line: 3},
+	}
+	stack := profileStackKey(frames)
+
+	p := &Profiler{
+		opts:    ProfileOptions{Interval: 10 * time.Millisecond},
+		start:   time.Unix(100, 0),
+		samples: map[profileKey]int{{stack: stack, vmstate: lua.VMInterpreted}: 5},
+		frames:  map[string][]lua.ProfileFrame{stack: frames},
+	}
+
+	fields, err := parseProto(p.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		strs      []string
+		samples   [][]protoField
+		locations = make(map[uint64][2]uint64) // Maps location IDs to their function ID and line
+		functions = make(map[uint64]string)    // Maps function IDs to their name
+		period    uint64
+	)
+	for _, f := range fields {
+		switch f.num {
+		case 6:
+			strs = append(strs, string(f.bytes))
+		case 12:
+			period = f.value
+		}
+	}
+
+	str := func(i uint64) string {
+		if i >= uint64(len(strs)) {
+			t.Fatalf("string %d out of the table", i)
+		}
+		return strs[i]
+	}
+
+	for _, f := range fields {
+		if f.num != 2 && f.num != 4 && f.num != 5 {
+			continue
+		}
+
+		m, err := parseProto(f.bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch f.num {
+		case 2:
+			samples = append(samples, m)
+		case 4:
+			var id, fid, line uint64
+			for _, lf := range m {
+				switch lf.num {
+				case 1:
+					id = lf.value
+				case 4:
+					lines, err := parseProto(lf.bytes)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, l := range lines {
+						switch l.num {
+						case 1:
+							fid = l.value
+						case 2:
+							line = l.value
+						}
+					}
+				}
+			}
+			locations[id] = [2]uint64{fid, line}
+		case 5:
+			var id, name uint64
+			for _, ff := range m {
+				switch ff.num {
+				case 1:
+					id = ff.value
+				case 2:
+					name = ff.value
+				}
+			}
+			functions[id] = str(name)
+		}
+	}
+
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("string table %q doesn't start with an empty string", strs)
+	}
+
+	if want := uint64(10 * time.Millisecond); period != want {
+		t.Errorf("got period %d, want %d", period, want)
+	}
+
+	if len(samples) != 1 {
+		t.Fatalf("got %d samples, want 1", len(samples))
+	}
+
+	var (
+		stackGot []string
+		values   []uint64
+		label    [2]string
+	)
+	for _, f := range samples[0] {
+		switch f.num {
+		case 1:
+			for _, id := range parsePacked(f.bytes) {
+				loc, ok := locations[id]
+				if !ok {
+					t.Fatalf("sample references unknown location %d", id)
+				}
+				stackGot = append(stackGot, fmt.Sprintf("%s:%d", functions[loc[0]], loc[1]))
+			}
+		case 2:
+			values = parsePacked(f.bytes)
+		case 3:
+			l, err := parseProto(f.bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, lf := range l {
+				if lf.num == 1 || lf.num == 2 {
+					label[lf.num-1] = str(lf.value)
+				}
+			}
+		}
+	}
+
+	if want := []string{"update:12", "main:3"}; !slices.Equal(stackGot, want) {
+		t.Errorf("got stack %v, want %v", stackGot, want)
+	}
+
+	if want := []uint64{5, uint64(50 * time.Millisecond)}; !slices.Equal(values, want) {
+		t.Errorf("got values %v, want %v", values, want)
+	}
+
+	if want := [2]string{"vmstate", "interpreted"}; label != want {
+		t.Errorf("got label %q, want %q", label, want)
+	}
+}
@@ -0,0 +1,233 @@
+package luajit
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// SandboxOption configures a state created by NewSandbox.
+type SandboxOption func(*sandbox)
+
+// Presets selecting the libraries of a sandbox, from the most to the least restricted.
+var (
+	// Minimal opens the base, string and table libraries.
+	Minimal = WithLibraries(lua.BaseLibName, lua.StringLibName, lua.TableLibName)
+
+	// Pure adds the math and bit libraries to Minimal, for scripts that only compute.
+	Pure = WithLibraries(lua.BaseLibName, lua.StringLibName, lua.TableLibName, lua.MathLibName, lua.BitLibName)
+
+	// Trusted adds the io, os, package and jit libraries to Pure, without the functions that escape the process.
+	Trusted = WithLibraries(lua.BaseLibName, lua.StringLibName, lua.TableLibName, lua.MathLibName, lua.BitLibName,
+		lua.IoLibName, lua.OsLibName, lua.LoadLibName, lua.JitLibName)
+)
+
+// WithLibraries opens the named libraries (lua.StringLibName, etc.) in addition to the ones already selected.
+//
+// The debug and ffi libraries give unrestricted access to the process, and are only opened when named here.
+func WithLibraries(names ...string) SandboxOption {
+	return func(sb *sandbox) {
+		for _, name := range names {
+			if !slices.Contains(sb.libs, name) {
+				sb.libs = append(sb.libs, name)
+			}
+		}
+	}
+}
+
+// AllowFunctions keeps the named functions, such as "os.exit", that sandboxes otherwise remove.
+func AllowFunctions(names ...string) SandboxOption {
+	return func(sb *sandbox) {
+		for _, name := range names {
+			sb.allowed[name] = true
+		}
+	}
+}
+
+// WithGlobal sets the global name to v, converted as in State.SetGlobal, before the globals are protected.
+func WithGlobal(name string, v any) SandboxOption {
+	return func(sb *sandbox) {
+		sb.globals = append(sb.globals, global{name, v})
+	}
+}
+
+type sandbox struct {
+	libs    []string
+	allowed map[string]bool
+	globals []global
+}
+
+type global struct {
+	name  string
+	value any
+}
+
+// unsafeFunctions are removed from sandboxes unless allowed.
+var unsafeFunctions = []string{
+	"dofile",
+	"loadfile",
+	"os.execute",
+	"os.exit",
+	"io.popen",
+	"package.loadlib",
+}
+
+var libOpeners = map[string]func(L lua.State) int{
+	lua.BaseLibName:      lua.OpenBase,
+	lua.CoroutineLibName: lua.OpenBase,
+	lua.MathLibName:      lua.OpenMath,
+	lua.StringLibName:    lua.OpenString,
+	lua.TableLibName:     lua.OpenTable,
+	lua.IoLibName:        lua.OpenIo,
+	lua.OsLibName:        lua.OpenOs,
+	lua.LoadLibName:      lua.OpenPackage,
+	lua.DbLibName:        lua.OpenDebug,
+	lua.BitLibName:       lua.OpenBit,
+	lua.JitLibName:       lua.OpenJit,
+	lua.FfiLibName:       lua.OpenFfi,
+}
+
+// sandboxSource is run last when creating a sandbox, with the globals table as argument.
+//
+// It prevents loading bytecode, which can break out of the VM,
+// and moves every global into a hidden table so they cannot be reassigned.
+const sandboxSource = `
+local G = ...
+local load, rawset, error, tostring, setmetatable, next = load, rawset, error, tostring, setmetatable, next
+
+if G.load then
+	G.load = function(chunk, name, mode, env)
+		return load(chunk, name, "t", env)
+	end
+end
+
+if G.loadstring then
+	G.loadstring = function(s, name)
+		return load(s, name, "t")
+	end
+end
+
+local protected = {}
+for k, v in next, G do
+	protected[k] = v
+end
+
+for k in next, protected do
+	G[k] = nil
+end
+
+local function check(t, k)
+	if t == G and protected[k] ~= nil then
+		error("cannot assign to protected global '" .. tostring(k) .. "'", 3)
+	end
+end
+
+if protected.rawset then
+	protected.rawset = function(t, k, v)
+		check(t, k)
+		return rawset(t, k, v)
+	end
+end
+
+setmetatable(G, {
+	__index = protected,
+	__newindex = function(t, k, v)
+		check(t, k)
+		rawset(t, k, v)
+	end,
+	__metatable = false,
+})
+`
+
+// NewSandbox creates a state restricted to the selected libraries, for running untrusted scripts.
+//
+// Without a preset (Minimal, Pure or Trusted) or WithLibraries, only the base library is opened.
+// Functions that run programs, exit the process, read files as code or load native code are removed,
+// and load and loadstring refuse bytecode.
+//
+// Once the state is set up, every global is protected: scripts can create globals,
+// but assigning a protected one raises an error.
+// Protected globals are not listed by pairs(_G).
+func NewSandbox(opts ...SandboxOption) (State, error) {
+	sb := &sandbox{allowed: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(sb)
+	}
+
+	if !slices.Contains(sb.libs, lua.BaseLibName) {
+		sb.libs = append([]string{lua.BaseLibName}, sb.libs...)
+	}
+
+	s := NewState()
+	if err := sb.setup(s); err != nil {
+		s.Close()
+		return 0, err
+	}
+
+	return s, nil
+}
+
+func (sb *sandbox) setup(s State) error {
+	L := s.thread()
+	for _, name := range sb.libs {
+		open, ok := libOpeners[name]
+		if !ok {
+			return fmt.Errorf("luajit: unknown library %q", name)
+		}
+
+		top := lua.GetTop(L)
+		open(L)
+		lua.SetTop(L, top)
+	}
+
+	for _, name := range unsafeFunctions {
+		if !sb.allowed[name] {
+			removeGlobal(L, name)
+		}
+	}
+
+	// Only keep the preload and Lua searchers, the others load native code like package.loadlib.
+	if !sb.allowed["package.loadlib"] && pushPackageField(L, "loaders") {
+		for i := lua.ObjLen(L, -1); i > 2; i-- {
+			lua.PushNil(L)
+			lua.RawSetI(L, -2, i)
+		}
+		lua.Pop(L, 1)
+	}
+
+	for _, g := range sb.globals {
+		if err := s.SetGlobal(g.name, g.value); err != nil {
+			return fmt.Errorf("luajit: setting global %q: %w", g.name, err)
+		}
+	}
+
+	if status := lua.LoadBuffer(L, []byte(sandboxSource), "=go-luajit.sandbox"); status != lua.StatusOk {
+		return popError(L, status)
+	}
+
+	lua.PushValue(L, lua.GlobalsIndex)
+	if status := lua.PCall(L, 1, 0, 0); status != lua.StatusOk {
+		return popError(L, status)
+	}
+
+	return nil
+}
+
+// removeGlobal removes a global, or a field of a global table if name is of the form "lib.field".
+func removeGlobal(L lua.State, name string) {
+	lib, field, ok := strings.Cut(name, ".")
+	if !ok {
+		lua.PushNil(L)
+		lua.SetGlobal(L, name)
+		return
+	}
+
+	lua.GetGlobal(L, lib)
+	if lua.IsTable(L, -1) {
+		lua.PushNil(L)
+		lua.SetField(L, -2, field)
+	}
+	lua.Pop(L, 1)
+}
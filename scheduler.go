@@ -0,0 +1,341 @@
+package luajit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+var errSchedulerClosed = errors.New("luajit: scheduler closed")
+
+// Scheduler runs Lua coroutines cooperatively, on the goroutine calling Run.
+//
+// It sets these globals in its state, which are timed by the Clock of the scheduler:
+//
+//	sleep(sec)              -- Suspends the calling coroutine for sec seconds
+//	spawn(fn, ...)          -- Runs fn with the arguments in a new coroutine
+//	cancel = after(sec, fn) -- Runs fn in a new coroutine after sec seconds, unless cancel is called
+//	cancel = every(sec, fn) -- Runs fn in a new coroutine every sec seconds, until cancel is called
+//	... = wait(event)       -- Suspends the calling coroutine until event is signaled, returning its values
+//	n = signal(event, ...)  -- Resumes the n coroutines waiting for event with the values
+//
+// Go functions that would block, such as receiving from a channel or functions registered with
+// State.RegisterAsync, suspend the coroutine calling them and let the others run until they complete.
+// Coroutines calling coroutine.yield are resumed after the other ready ones.
+//
+// Channels pushed to Lua have these methods, whose values are converted as by Marshal and Unmarshal:
+//
+//	ch:send(v)
+//	v, ok = ch:recv()               -- ok is false if the channel is closed
+//	v, ok = ch:try_recv()           -- Never blocks, ok is nil if no value is ready
+//	ch:close()
+//	i, v, ok = ch:select(ch2, ...)  -- Receives from the first ready channel, i being its position
+//
+// send, recv and select suspend the calling coroutine when it's run by a scheduler.
+// Elsewhere, they block until they complete or the context of the running call is done.
+//
+// A state has at most one scheduler, and the scheduler must only be used from the goroutine using the state.
+type Scheduler struct {
+	s       State
+	ctx     context.Context // The context of Run, cancelled once it returns
+	tasks   map[lua.State]*task
+	ready   []*task
+	pending int         // The number of operations running in goroutines
+	wake    chan func() // Receives the completion of operations, to run on the scheduler's goroutine
+	stop    chan struct{}
+	once    sync.Once
+	clock   Clock
+	timers  timerHeap
+	seq     int                // The number of timers created
+	events  map[string][]*task // The coroutines waiting for each event
+	err     error              // Returned by Run once set
+}
+
+// SchedulerOption configures a Scheduler.
+type SchedulerOption func(*Scheduler)
+
+// WithClock makes the scheduler use c for its timers, instead of the system's clock.
+func WithClock(c Clock) SchedulerOption {
+	return func(sc *Scheduler) {
+		sc.clock = c
+	}
+}
+
+// task is a coroutine run by a scheduler.
+type task struct {
+	co      *Coroutine
+	resume  func(L lua.State) (int, error) // Pushes the values to resume the coroutine with
+	waiting bool                           // If an operation will resume the coroutine
+}
+
+// NewScheduler creates the scheduler of s, and sets its globals.
+// If they cannot be set, such as in a sandbox protecting them, the first call to Run fails.
+func NewScheduler(s State, opts ...SchedulerOption) *Scheduler {
+	sc := &Scheduler{
+		s:      s,
+		tasks:  make(map[lua.State]*task),
+		wake:   make(chan func()),
+		stop:   make(chan struct{}),
+		clock:  realClock{},
+		events: make(map[string][]*task),
+	}
+
+	for _, opt := range opts {
+		opt(sc)
+	}
+
+	s.data().sched = sc
+	// Run reports the globals that can't be set, such as the protected globals of a sandbox.
+	sc.err = sc.openPrimitives()
+	return sc
+}
+
+// State returns the state of the scheduler.
+func (sc *Scheduler) State() State {
+	return sc.s
+}
+
+// Spawn adds a coroutine running fn with args, which starts when Run is called.
+// It fails once the scheduler is closed.
+func (sc *Scheduler) Spawn(fn *Function, args ...any) error {
+	if sc.closed() {
+		return errSchedulerClosed
+	}
+
+	co, err := sc.s.NewCoroutine(fn)
+	if err != nil {
+		return err
+	}
+
+	t := &task{co: co, resume: func(L lua.State) (int, error) {
+		return len(args), pushValues(L, args...)
+	}}
+	sc.tasks[co.co] = t
+	sc.ready = append(sc.ready, t)
+	return nil
+}
+
+// Signal resumes the coroutines waiting for event with args, returning their number.
+func (sc *Scheduler) Signal(event string, args ...any) int {
+	waiting := sc.events[event]
+	delete(sc.events, event)
+
+	n := 0
+	for _, t := range waiting {
+		if sc.resumeTask(t, func(L lua.State) (int, error) {
+			return len(args), pushValues(L, args...)
+		}) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Run runs coroutines until no work remains or ctx is done.
+//
+// Work remains while coroutines are ready, wait for an operation running in a goroutine,
+// or timers are set. Coroutines waiting for an event that's never signaled don't keep Run going.
+// With a FakeClock, the clock is advanced to the next timer whenever nothing else can run.
+//
+// If a coroutine fails, Run stops and returns its error.
+// The remaining coroutines are kept, and continue when Run is called again.
+// Operations still running when Run returns are cancelled, see State.RegisterAsync.
+func (sc *Scheduler) Run(ctx context.Context) error {
+	// Operations started during the run are cancelled once it returns.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sc.ctx = ctx
+	for {
+		sc.fireTimers()
+		for len(sc.ready) > 0 {
+			t := sc.ready[0]
+			sc.ready = sc.ready[1:]
+			if err := sc.step(t); err != nil {
+				return err
+			}
+		}
+
+		if err := sc.err; err != nil {
+			sc.err = nil
+			return err
+		}
+
+		next, ok := sc.nextTimer()
+		if sc.pending == 0 {
+			if !ok {
+				return nil
+			}
+
+			if fc, fake := sc.clock.(*FakeClock); fake {
+				fc.mu.Lock()
+				fc.advanceTo(next)
+				fc.mu.Unlock()
+				continue
+			}
+		}
+
+		var timeout <-chan time.Time
+		if ok {
+			timeout = sc.clock.After(next.Sub(sc.clock.Now()))
+		}
+
+		err := sc.wait(ctx, timeout)
+		if fc, fake := sc.clock.(*FakeClock); fake && timeout != nil {
+			fc.stop(timeout)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// wait waits for an operation to complete, timeout or ctx to be done.
+func (sc *Scheduler) wait(ctx context.Context, timeout <-chan time.Time) error {
+	select {
+	case f := <-sc.wake:
+		f()
+	case <-timeout:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// Close stops the scheduler, abandoning its coroutines and the operations they wait for.
+func (sc *Scheduler) Close() {
+	sc.once.Do(func() {
+		close(sc.stop)
+		for _, t := range sc.tasks {
+			t.co.Close()
+		}
+
+		clear(sc.tasks)
+		clear(sc.events)
+		sc.ready = nil
+		sc.timers = nil
+		if d := sc.s.data(); d.sched == sc {
+			d.sched = nil
+		}
+	})
+}
+
+// cause returns the error of an operation interrupted by ctx, errSchedulerClosed if Close was called.
+func (sc *Scheduler) cause(ctx context.Context) error {
+	if sc.closed() {
+		return errSchedulerClosed
+	}
+
+	return ctx.Err()
+}
+
+// closed returns whether Close was called.
+func (sc *Scheduler) closed() bool {
+	select {
+	case <-sc.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// step resumes a ready task.
+func (sc *Scheduler) step(t *task) error {
+	resume := t.resume
+	t.resume = nil
+	if resume == nil {
+		resume = func(L lua.State) (int, error) { return 0, nil }
+	}
+
+	_, done, err := t.co.resume(resume)
+	switch {
+	case err != nil || done:
+		delete(sc.tasks, t.co.co)
+		t.co.Close()
+		return err
+	case !t.waiting:
+		sc.ready = append(sc.ready, t)
+	}
+
+	return nil
+}
+
+// taskOf returns the task running on L, or nil if L isn't a coroutine run by a scheduler.
+func taskOf(L lua.State) (*Scheduler, *task) {
+	sc := State(L).data().sched
+	if sc == nil || sc.tasks == nil {
+		return nil, nil
+	}
+
+	t := sc.tasks[L]
+	if t == nil {
+		return nil, nil
+	}
+
+	return sc, t
+}
+
+// await suspends t until op, run in a goroutine, completes.
+//
+// op must return once ctx is done, which happens when Run returns or the scheduler is closed.
+// The callable it returns pushes the results to resume t with, and runs on the scheduler's goroutine.
+// The caller must then return errYield.
+func (sc *Scheduler) await(t *task, op func(ctx context.Context) callable) {
+	t.waiting = true
+	sc.pending++
+
+	ctx, cancel := context.WithCancel(sc.ctx)
+	go func() {
+		select {
+		case <-sc.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	go func() {
+		result := op(ctx)
+		cancel()
+		complete := func() {
+			sc.pending--
+			sc.resumeTask(t, result)
+		}
+
+		select {
+		case sc.wake <- complete:
+		case <-sc.stop:
+		}
+	}()
+}
+
+// resumeTask makes t ready to be resumed with the results of fn, returning false if t was removed.
+func (sc *Scheduler) resumeTask(t *task, fn callable) bool {
+	if sc.tasks[t.co.co] != t {
+		return false
+	}
+
+	t.waiting = false
+	t.resume = func(L lua.State) (int, error) {
+		return pushResults(L, fn), nil
+	}
+	sc.ready = append(sc.ready, t)
+	return true
+}
+
+// pushResults calls fn and pushes its results in the form returned by dispatch, returning their number.
+func pushResults(L lua.State, fn callable) int {
+	n, err := safeCall(L, fn)
+	if err != nil {
+		pushFailure(L, err)
+		return 3
+	}
+
+	lua.PushBoolean(L, true)
+	lua.Insert(L, -n-1)
+	return n + 1
+}
@@ -0,0 +1,65 @@
+package luajit
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSchedulerFakeClock(t *testing.T) {
+	s := newTestState(t)
+	start := time.Unix(0, 0)
+	clock := NewFakeClock(start)
+	sc := NewScheduler(s, WithClock(clock))
+	defer sc.Close()
+
+	fn, err := s.LoadString(`
+		log = {}
+		spawn(function() sleep(2) log[#log + 1] = "slow" end)
+		spawn(function() sleep(1) log[#log + 1] = "fast" end)
+
+		local n, cancel = 0
+		cancel = every(0.4, function()
+			n = n + 1
+			log[#log + 1] = "tick" .. n
+			if n == 3 then cancel() end
+		end)
+	`, "=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sc.Spawn(fn); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sc.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.GetGlobal("log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range v.Table().Ipairs() {
+		got = append(got, e.String())
+	}
+
+	want := []string{"tick1", "tick2", "fast", "tick3", "slow"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if elapsed := clock.Now().Sub(start); elapsed != 2*time.Second {
+		t.Errorf("clock advanced by %v, want 2s", elapsed)
+	}
+
+	if n := len(clock.waiters); n != 0 {
+		t.Errorf("got %d clock waiters left, want 0", n)
+	}
+}
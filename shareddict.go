@@ -0,0 +1,465 @@
+package luajit
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+var (
+	// ErrExists is returned by SharedDict.Add when the key already exists.
+	ErrExists = errors.New("luajit: key exists")
+
+	// ErrNotFound is returned by SharedDict.Incr when the key doesn't exist.
+	ErrNotFound = errors.New("luajit: key not found")
+
+	// ErrNotNumber is returned by SharedDict.Incr when the value isn't a number.
+	ErrNotNumber = errors.New("luajit: value not a number")
+)
+
+// SharedDict is a dictionary shared by states, and safe for concurrent use.
+//
+// It only stores booleans, numbers and strings, which are copied in and out of it.
+// Values may expire after a time to live, and the least recently used ones are evicted
+// to stay within the size cap.
+//
+// A SharedDict is converted to a userdata when pushed to Lua, such as with State.SetGlobal.
+// In Lua, it has these methods, which report failures like OpenResty's ngx.shared.DICT:
+//
+//	v = dict:get(key)                          -- nil if the key doesn't exist or has expired
+//	ok, err, forcible = dict:set(key, v, ttl)  -- Setting nil deletes the key, forcible is true if others were evicted
+//	ok, err, forcible = dict:add(key, v, ttl)  -- Fails with "exists" if the key exists
+//	n, err = dict:incr(key, delta, init)       -- Starts from init if given and the key doesn't exist
+//	dict:delete(key)
+//	keys = dict:keys(max)                      -- At most max keys if max is positive
+//
+// ttl is in seconds, and values never expire when it's 0 or absent.
+type SharedDict struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	lru     list.List // Most recently used first
+	now     func() time.Time
+}
+
+// dictEntry is an entry of a SharedDict.
+type dictEntry struct {
+	key     string
+	value   any // bool, float64 or string
+	expires time.Time
+}
+
+// NewSharedDict creates a dictionary holding up to max keys, or any number of keys if max is 0.
+func NewSharedDict(max int) *SharedDict {
+	return &SharedDict{max: max, entries: make(map[string]*list.Element), now: time.Now}
+}
+
+// Get returns the value of key, and if it exists.
+func (d *SharedDict) Get(key string) (any, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e := d.lookup(key)
+	if e == nil {
+		return nil, false
+	}
+
+	return e.Value.(*dictEntry).value, true
+}
+
+// Set sets the value of key, expiring after ttl if it's positive.
+// A nil value deletes key.
+//
+// forcible is true if other keys were evicted to make room for key.
+func (d *SharedDict) Set(key string, v any, ttl time.Duration) (forcible bool, err error) {
+	return d.store(key, v, ttl, false)
+}
+
+// Add sets the value of key like Set, failing with ErrExists if key exists.
+func (d *SharedDict) Add(key string, v any, ttl time.Duration) (forcible bool, err error) {
+	return d.store(key, v, ttl, true)
+}
+
+// Incr adds delta to the number stored in key and returns the result.
+//
+// If key doesn't exist, it's set to init plus delta if init isn't nil, and Incr fails with ErrNotFound otherwise.
+func (d *SharedDict) Incr(key string, delta float64, init *float64) (float64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e := d.lookup(key); e != nil {
+		de := e.Value.(*dictEntry)
+		n, ok := de.value.(float64)
+		if !ok {
+			return 0, ErrNotNumber
+		}
+
+		de.value = n + delta
+		return n + delta, nil
+	}
+
+	if init == nil {
+		return 0, ErrNotFound
+	}
+
+	d.insert(key, *init+delta, 0)
+	return *init + delta, nil
+}
+
+// Delete removes key.
+func (d *SharedDict) Delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.entries[key]; ok {
+		d.remove(e)
+	}
+}
+
+// Keys returns the keys that haven't expired, at most max if it's positive.
+func (d *SharedDict) Keys(max int) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	var keys []string
+	for e := d.lru.Front(); e != nil && (max <= 0 || len(keys) < max); e = e.Next() {
+		if de := e.Value.(*dictEntry); !de.expired(now) {
+			keys = append(keys, de.key)
+		}
+	}
+
+	return keys
+}
+
+// Len returns the number of keys, including the expired ones not removed yet.
+func (d *SharedDict) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries)
+}
+
+// store sets the value of key, failing if it exists and add is true.
+func (d *SharedDict) store(key string, v any, ttl time.Duration, add bool) (bool, error) {
+	v, err := dictValue(v)
+	if err != nil {
+		return false, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e := d.lookup(key)
+	switch {
+	case add && e != nil:
+		return false, ErrExists
+	case v == nil:
+		if e != nil {
+			d.remove(e)
+		}
+		return false, nil
+	case e != nil:
+		de := e.Value.(*dictEntry)
+		de.value, de.expires = v, d.expiry(ttl)
+		return false, nil
+	}
+
+	return d.insert(key, v, ttl), nil
+}
+
+// insert adds a new key, evicting others if needed and returning if it did.
+func (d *SharedDict) insert(key string, v any, ttl time.Duration) bool {
+	forcible := false
+	if d.max > 0 && len(d.entries) >= d.max {
+		d.purge()
+		for len(d.entries) >= d.max {
+			d.remove(d.lru.Back())
+			forcible = true
+		}
+	}
+
+	d.entries[key] = d.lru.PushFront(&dictEntry{key: key, value: v, expires: d.expiry(ttl)})
+	return forcible
+}
+
+// lookup returns the entry of key if it exists and hasn't expired, marking it as recently used.
+func (d *SharedDict) lookup(key string) *list.Element {
+	e, ok := d.entries[key]
+	if !ok {
+		return nil
+	}
+
+	if e.Value.(*dictEntry).expired(d.now()) {
+		d.remove(e)
+		return nil
+	}
+
+	d.lru.MoveToFront(e)
+	return e
+}
+
+// purge removes the expired entries.
+func (d *SharedDict) purge() {
+	now := d.now()
+	for e := d.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*dictEntry).expired(now) {
+			d.remove(e)
+		}
+		e = next
+	}
+}
+
+func (d *SharedDict) remove(e *list.Element) {
+	delete(d.entries, e.Value.(*dictEntry).key)
+	d.lru.Remove(e)
+}
+
+// expiry returns the expiration time of a value set now with ttl.
+func (d *SharedDict) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return d.now().Add(ttl)
+}
+
+func (de *dictEntry) expired(now time.Time) bool {
+	return !de.expires.IsZero() && !now.Before(de.expires)
+}
+
+// dictValue returns v as stored by a SharedDict, converting numbers to float64.
+func dictValue(v any) (any, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil, nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	}
+
+	return nil, fmt.Errorf("luajit: cannot store %T in a shared dictionary", v)
+}
+
+var sharedDictType = reflect.TypeFor[*SharedDict]()
+
+// dictMeta is the metatable of shared dictionaries, see SharedDict for their methods.
+var dictMeta = &metatable{name: "go-luajit.dict"}
+
+func init() {
+	dictMeta.init = initDictMeta
+}
+
+var dictMethods = map[string]callable{
+	"get":    dictGet,
+	"set":    dictSet,
+	"add":    dictAdd,
+	"incr":   dictIncr,
+	"delete": dictDelete,
+	"keys":   dictKeys,
+}
+
+func initDictMeta(L lua.State) {
+	lua.CreateTable(L, 0, len(dictMethods))
+	for name, c := range dictMethods {
+		pushCallable(L, c)
+		lua.SetField(L, -2, name)
+	}
+	lua.SetField(L, -2, "__index")
+
+	pushCallable(L, func(L lua.State) (int, error) {
+		lua.PushString(L, fmt.Sprintf("shared dict: %p", toHandle(L, 1)))
+		return 1, nil
+	})
+	lua.SetField(L, -2, "__tostring")
+}
+
+// checkDict returns the dictionary the method name is called on, and its key argument.
+func checkDict(L lua.State, name string) (*SharedDict, string, error) {
+	d, ok := toHandle(L, 1).(*SharedDict)
+	if !ok {
+		err := &TypeError{Expected: "shared dict", Got: lua.TypeNameOf(L, 1)}
+		return nil, "", &ArgError{Arg: 1, Func: name, Err: &UnmarshalError{Err: err}}
+	}
+
+	if name == "keys" {
+		return d, "", nil
+	}
+
+	if lua.Type(L, 2) != lua.TString {
+		err := &TypeError{Expected: "string", Got: lua.TypeNameOf(L, 2)}
+		return nil, "", &ArgError{Arg: 2, Func: name, Err: &UnmarshalError{Err: err}}
+	}
+
+	return d, string(lua.ToBytes(L, 2)), nil
+}
+
+// toDictValue returns the value at idx as stored by a SharedDict.
+func toDictValue(L lua.State, idx int, name string) (any, error) {
+	switch lua.Type(L, idx) {
+	case lua.TNone, lua.TNil:
+		return nil, nil
+	case lua.TBoolean:
+		return lua.ToBoolean(L, idx), nil
+	case lua.TNumber:
+		return float64(lua.ToNumber(L, idx)), nil
+	case lua.TString:
+		return string(lua.ToBytes(L, idx)), nil
+	}
+
+	err := fmt.Errorf("cannot store %s in a shared dictionary", lua.TypeNameOf(L, idx))
+	return nil, &ArgError{Arg: idx, Func: name, Err: err}
+}
+
+// toTTL returns the time to live in seconds at idx, which may be absent.
+func toTTL(L lua.State, idx int, name string) (time.Duration, error) {
+	if lua.IsNoneOrNil(L, idx) {
+		return 0, nil
+	}
+
+	return checkSeconds(L, idx, name)
+}
+
+func dictGet(L lua.State) (int, error) {
+	d, key, err := checkDict(L, "get")
+	if err != nil {
+		return 0, err
+	}
+
+	v, _ := d.Get(key)
+	return 1, pushValue(L, v)
+}
+
+func dictSet(L lua.State) (int, error) {
+	return dictStore(L, "set", (*SharedDict).Set)
+}
+
+func dictAdd(L lua.State) (int, error) {
+	return dictStore(L, "add", (*SharedDict).Add)
+}
+
+// dictStore calls store with the arguments of the method name, pushing ok, err and forcible.
+func dictStore(L lua.State, name string, store func(d *SharedDict, key string, v any, ttl time.Duration) (bool, error)) (int, error) {
+	d, key, err := checkDict(L, name)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := toDictValue(L, 3, name)
+	if err != nil {
+		return 0, err
+	}
+
+	ttl, err := toTTL(L, 4, name)
+	if err != nil {
+		return 0, err
+	}
+
+	forcible, err := store(d, key, v, ttl)
+	lua.PushBoolean(L, err == nil)
+	pushDictError(L, err)
+	lua.PushBoolean(L, forcible)
+	return 3, nil
+}
+
+func dictIncr(L lua.State) (int, error) {
+	d, key, err := checkDict(L, "incr")
+	if err != nil {
+		return 0, err
+	}
+
+	delta := 1.0
+	if !lua.IsNoneOrNil(L, 3) {
+		if lua.Type(L, 3) != lua.TNumber {
+			err := &TypeError{Expected: "number", Got: lua.TypeNameOf(L, 3)}
+			return 0, &ArgError{Arg: 3, Func: "incr", Err: &UnmarshalError{Err: err}}
+		}
+		delta = float64(lua.ToNumber(L, 3))
+	}
+
+	var init *float64
+	if !lua.IsNoneOrNil(L, 4) {
+		if lua.Type(L, 4) != lua.TNumber {
+			err := &TypeError{Expected: "number", Got: lua.TypeNameOf(L, 4)}
+			return 0, &ArgError{Arg: 4, Func: "incr", Err: &UnmarshalError{Err: err}}
+		}
+		n := float64(lua.ToNumber(L, 4))
+		init = &n
+	}
+
+	n, err := d.Incr(key, delta, init)
+	if err != nil {
+		lua.PushNil(L)
+	} else {
+		lua.PushNumber(L, lua.Number(n))
+	}
+	pushDictError(L, err)
+	return 2, nil
+}
+
+func dictDelete(L lua.State) (int, error) {
+	d, key, err := checkDict(L, "delete")
+	if err != nil {
+		return 0, err
+	}
+
+	d.Delete(key)
+	return 0, nil
+}
+
+func dictKeys(L lua.State) (int, error) {
+	d, _, err := checkDict(L, "keys")
+	if err != nil {
+		return 0, err
+	}
+
+	max := 0
+	if lua.Type(L, 2) == lua.TNumber {
+		max = int(min(float64(lua.ToNumber(L, 2)), math.MaxInt32))
+	}
+
+	keys := d.Keys(max)
+	if keys == nil {
+		keys = []string{}
+	}
+
+	return 1, pushValue(L, keys)
+}
+
+// dictMessages are the messages of the errors of SharedDict in Lua.
+var dictMessages = map[error]string{
+	ErrExists:    "exists",
+	ErrNotFound:  "not found",
+	ErrNotNumber: "not a number",
+}
+
+// pushDictError pushes the message of err, or nil.
+func pushDictError(L lua.State, err error) {
+	if err == nil {
+		lua.PushNil(L)
+		return
+	}
+
+	msg, ok := dictMessages[err]
+	if !ok {
+		msg = err.Error()
+	}
+
+	lua.PushString(L, msg)
+}
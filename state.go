@@ -1,15 +1,265 @@
 package luajit
 
 import (
+	"sync"
+
 	"github.com/judah-caruso/go-luajit/lua"
 )
 
+// State represents a Lua state.
+//
+// A State is not safe for concurrent use.
+// Built with the luajit_debug tag, using a state from another goroutine than the one that created it panics,
+// unless it's transferred with State.Adopt. Locked serializes the use of a state with a mutex instead.
 type State lua.State
 
+// NewState creates a new Lua state.
 func NewState() State {
-	return State(lua.NewState())
+	s := State(lua.NewState())
+	s.data()
+	return s
 }
 
+// Close destroys all objects in the state and frees its memory.
 func (s State) Close() {
-	lua.Close(lua.State(s))
+	d := s.data()
+	lua.Close(d.main)
+	d.closed = true
+
+	states.Lock()
+	for L, sd := range states.m {
+		if sd == d {
+			delete(states.m, L)
+		}
+	}
+	states.Unlock()
+}
+
+// Adopt makes the calling goroutine the owner of the state, after another goroutine is done with it.
+//
+// Ownership is only checked when built with the luajit_debug tag.
+func (s State) Adopt() {
+	s.load().adopt()
+}
+
+// OpenLibs opens all standard Lua libraries into the state.
+func (s State) OpenLibs() {
+	lua.OpenLibs(s.thread())
+}
+
+// DoString loads and runs the given string.
+func (s State) DoString(src string) error {
+	fn, err := s.LoadString(src, src)
+	if err != nil {
+		return err
+	}
+
+	_, err = fn.Call()
+	return err
+}
+
+// LoadString loads a string as a Lua chunk without running it.
+//
+// 'name' is the chunk name used in error messages.
+func (s State) LoadString(src, name string) (*Function, error) {
+	L := s.thread()
+	if status := lua.LoadBuffer(L, []byte(src), name); status != lua.StatusOk {
+		return nil, popError(L, status)
+	}
+
+	return &Function{ref: popReference(L)}, nil
+}
+
+// Globals returns the table of globals.
+func (s State) Globals() *Table {
+	L := s.thread()
+	lua.PushValue(L, lua.GlobalsIndex)
+	return &Table{ref: popReference(L)}
+}
+
+// GetGlobal returns the value of the global name.
+//
+// Metamethods of the globals table are called, and their errors returned.
+func (s State) GetGlobal(name string) (Value, error) {
+	L := s.thread()
+	if err := getGlobal(L, name); err != nil {
+		return Value{}, err
+	}
+
+	return popValue(L), nil
+}
+
+// SetGlobal sets the global name to v, converted to a Lua value.
+//
+// Metamethods of the globals table are called, and their errors returned,
+// such as when assigning a protected global of a sandbox.
+func (s State) SetGlobal(name string, v any) error {
+	L := s.thread()
+	if err := pushValue(L, v); err != nil {
+		return err
+	}
+
+	return setGlobal(L, name)
+}
+
+// getGlobal pushes the value of the global name, calling the metamethods of the globals table.
+// Nothing is pushed on failure.
+func getGlobal(L lua.State, name string) error {
+	lua.CheckStack(L, 3)
+	pushHelper(L, "get")
+	lua.PushValue(L, lua.GlobalsIndex)
+	lua.PushString(L, name)
+	if status := lua.PCall(L, 2, 1, 0); status != lua.StatusOk {
+		return popError(L, status)
+	}
+
+	return nil
+}
+
+// setGlobal pops a value and assigns it to the global name, calling the metamethods of the globals table.
+func setGlobal(L lua.State, name string) error {
+	lua.CheckStack(L, 3)
+	pushHelper(L, "set")               // v set
+	lua.Insert(L, -2)                  // set v
+	lua.PushValue(L, lua.GlobalsIndex) // set v G
+	lua.Insert(L, -2)                  // set G v
+	lua.PushString(L, name)            // set G v name
+	lua.Insert(L, -2)                  // set G name v
+	if status := lua.PCall(L, 3, 0, 0); status != lua.StatusOk {
+		return popError(L, status)
+	}
+
+	return nil
+}
+
+// NewTable creates a new empty table.
+func (s State) NewTable() *Table {
+	L := s.thread()
+	lua.NewTable(L)
+	return &Table{ref: popReference(L)}
+}
+
+// RegisterFunc sets the global name to the Go function fn.
+//
+// Arguments are converted from Lua to the parameter types of fn,
+// and each result of fn is returned to Lua.
+// If the last result of fn is an error and it is not nil, a Lua error is raised instead.
+//
+// Supported parameter types are bools, numbers, strings, slices, arrays, maps, structs,
+// pointers to these, Value, *Table, *Function and *Coroutine.
+// Variadic functions receive the remaining arguments.
+// If the first parameter is a context.Context, it receives the context of the call running the function
+// (see State.CallContext) instead of an argument.
+func (s State) RegisterFunc(name string, fn any) error {
+	L := s.thread()
+	if err := pushFunc(L, name, fn); err != nil {
+		return err
+	}
+
+	return setGlobal(L, name)
+}
+
+// thread returns the thread currently running in the state.
+func (s State) thread() lua.State {
+	return s.data().current
+}
+
+// stateData holds the Go side of a state.
+//
+// It is shared by every thread of the state.
+type stateData struct {
+	main    lua.State // The main thread
+	current lua.State // The thread currently running Go code
+	limited int       // The number of calls running with limits, during which the JIT compiler is off
+	jitOn   bool      // Whether the JIT compiler was on before the outermost call with limits
+	lim     *limiter  // The limits of the innermost call running with limits, if any
+	sched   *Scheduler
+	guard              // Checks the goroutine using the state
+	lock    sync.Mutex // Held by LockedState
+	closed  bool       // Set by Close, checked by LockedState under lock
+
+	mu   sync.Mutex // Guards dead
+	dead []int      // References released by the garbage collector
+}
+
+// states maps main threads, and the threads of the coroutines referenced by Go, to the data of their state,
+// so that it's found without touching them.
+//
+// Coroutines are removed once their reference is released, so they're never collected while in the map.
+// Other threads, created by Lua code, are looked up through the registry.
+var states struct {
+	sync.Mutex
+	m map[lua.State]*stateData
+}
+
+const stateKey = "go-luajit.state"
+
+// data returns the data for the state of the given thread, creating it if needed.
+//
+// The owner of the state is checked before the thread is used.
+func (s State) data() *stateData {
+	d := s.load()
+	d.check()
+	d.collect()
+	return d
+}
+
+// load returns the data for the state of the given thread, creating it if needed, without checking its owner.
+func (s State) load() *stateData {
+	L := lua.State(s)
+
+	states.Lock()
+	d, ok := states.m[L]
+	states.Unlock()
+	if ok {
+		return d
+	}
+
+	// Threads created by Lua code are found through the registry.
+	lua.GetField(L, lua.RegistryIndex, stateKey)
+	main := lua.State(lua.ToUserdata(L, -1))
+	lua.Pop(L, 1)
+
+	states.Lock()
+	d, ok = states.m[main]
+	states.Unlock()
+
+	if !ok {
+		// Only the main thread can reach this point, as threads are created from an existing state.
+		main = L
+		d = &stateData{main: main, current: main}
+		d.adopt()
+
+		states.Lock()
+		if states.m == nil {
+			states.m = make(map[lua.State]*stateData)
+		}
+		states.m[main] = d
+		states.Unlock()
+
+		lua.PushLightUserdata(L, uintptr(main))
+		lua.SetField(L, lua.RegistryIndex, stateKey)
+		openHelpers(L)
+	}
+
+	return d
+}
+
+// collect releases the references dropped by Go since the last call.
+func (d *stateData) collect() {
+	d.mu.Lock()
+	dead := d.dead
+	d.dead = nil
+	d.mu.Unlock()
+
+	for _, id := range dead {
+		lua.Unref(d.current, lua.RegistryIndex, id)
+	}
+}
+
+// enter marks L as the thread running Go code until the returned function is called.
+func (d *stateData) enter(L lua.State) (leave func()) {
+	prev := d.current
+	d.current = L
+	return func() { d.current = prev }
 }
@@ -0,0 +1,68 @@
+package luajit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// newTestState returns a state with the standard libraries open, closed at the end of the test.
+// The test is skipped if LuaJIT's library isn't available.
+func newTestState(t *testing.T) State {
+	t.Helper()
+	if err := lua.LoadError(); err != nil {
+		t.Skipf("luajit not available: %v", err)
+	}
+
+	s := NewState()
+	s.OpenLibs()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestBadArgument(t *testing.T) {
+	s := newTestState(t)
+	if err := s.RegisterFunc("add", func(a, b int) int { return a + b }); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`add(1, "x")`, "bad argument #2 to 'add'"},
+		{`add({}, 2)`, "bad argument #1 to 'add' (number expected, got table)"},
+		{`local ok, err = pcall(add, 1, true) error(err, 0)`, "bad argument #2 to 'add' (number expected, got boolean)"},
+	}
+
+	for _, tt := range tests {
+		err := s.DoString(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	s := newTestState(t)
+	if err := s.SetGlobal("answer", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.GetGlobal("answer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Int() != 42 {
+		t.Errorf("got %v, want 42", v)
+	}
+
+	// Metamethods of the globals table that fail are reported rather than raised.
+	if err := s.DoString(`setmetatable(_G, {__newindex = function() error("read-only") end})`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetGlobal("other", 1); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("got error %v, want read-only", err)
+	}
+}
@@ -0,0 +1,213 @@
+package luajit
+
+import (
+	"iter"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// Table is a reference to a Lua table.
+type Table struct {
+	ref *reference
+}
+
+// Get returns t[key], following the semantics of Lua indexing (that is, may call metamethods).
+func (t *Table) Get(key any) (Value, error) {
+	L := t.ref.thread()
+	top := lua.GetTop(L)
+
+	pushHelper(L, "get")
+	t.ref.push(L)
+	if err := pushValue(L, key); err != nil {
+		lua.SetTop(L, top)
+		return Value{}, err
+	}
+
+	if status := lua.PCall(L, 2, 1, 0); status != lua.StatusOk {
+		return Value{}, popError(L, status)
+	}
+
+	return popValue(L), nil
+}
+
+// Set does the equivalent of t[key] = value, following the semantics of Lua assignment (that is, may call metamethods).
+func (t *Table) Set(key, value any) error {
+	L := t.ref.thread()
+	top := lua.GetTop(L)
+
+	pushHelper(L, "set")
+	t.ref.push(L)
+	if err := pushValues(L, key, value); err != nil {
+		lua.SetTop(L, top)
+		return err
+	}
+
+	if status := lua.PCall(L, 3, 0, 0); status != lua.StatusOk {
+		return popError(L, status)
+	}
+
+	return nil
+}
+
+// RawGet returns t[key] without calling metamethods.
+func (t *Table) RawGet(key any) (Value, error) {
+	L := t.ref.thread()
+	t.ref.push(L)
+	if err := pushValue(L, key); err != nil {
+		lua.Pop(L, 1)
+		return Value{}, err
+	}
+
+	lua.RawGet(L, -2)
+	v := popValue(L)
+	lua.Pop(L, 1)
+	return v, nil
+}
+
+// RawSet does the equivalent of t[key] = value without calling metamethods.
+func (t *Table) RawSet(key, value any) error {
+	L := t.ref.thread()
+	t.ref.push(L)
+	if err := pushKey(L, key); err != nil {
+		lua.Pop(L, 1)
+		return err
+	}
+
+	if err := pushValue(L, value); err != nil {
+		lua.Pop(L, 2)
+		return err
+	}
+
+	lua.RawSet(L, -3)
+	lua.Pop(L, 1)
+	return nil
+}
+
+// Len returns the length of the table, as given by the '#' operator without metamethods.
+func (t *Table) Len() int {
+	L := t.ref.thread()
+	t.ref.push(L)
+	defer lua.Pop(L, 1)
+	return lua.ObjLen(L, -1)
+}
+
+// SetFunc sets t[name] to the Go function fn, without calling metamethods.
+//
+// See State.RegisterFunc for how arguments and results are converted.
+func (t *Table) SetFunc(name string, fn any) error {
+	L := t.ref.thread()
+	t.ref.push(L)
+	if err := pushFunc(L, name, fn); err != nil {
+		lua.Pop(L, 1)
+		return err
+	}
+
+	lua.SetField(L, -2, name)
+	lua.Pop(L, 1)
+	return nil
+}
+
+// All returns an iterator over the key-value pairs of t, without calling metamethods.
+//
+// As with Lua's next, fields can be assigned or cleared during the loop, but not added.
+// The loop stops early if the traversal fails, see AllErr to tell why.
+func (t *Table) All() iter.Seq2[Value, Value] {
+	return t.pairs(true, new(error))
+}
+
+// AllErr is like All, but also returns a function reporting why the last loop stopped early,
+// or nil if it went through every field or was stopped by its body.
+func (t *Table) AllErr() (iter.Seq2[Value, Value], func() error) {
+	err := new(error)
+	return t.pairs(true, err), func() error { return *err }
+}
+
+// Pairs returns an iterator over t like Lua's pairs, using the __pairs metamethod of t if it has one.
+//
+// The loop stops early if the iteration fails, such as when the metamethod raises an error,
+// see PairsErr to tell why.
+func (t *Table) Pairs() iter.Seq2[Value, Value] {
+	return t.pairs(false, new(error))
+}
+
+// PairsErr is like Pairs, but also returns a function reporting why the last loop stopped early,
+// or nil if the iteration completed or was stopped by its body.
+func (t *Table) PairsErr() (iter.Seq2[Value, Value], func() error) {
+	err := new(error)
+	return t.pairs(false, err), func() error { return *err }
+}
+
+// pairs returns an iterator over t, setting *errp to why the iteration failed.
+func (t *Table) pairs(raw bool, errp *error) iter.Seq2[Value, Value] {
+	return func(yield func(Value, Value) bool) {
+		// Nothing is kept on the stack while the loop body runs.
+		L := t.ref.thread()
+		top := lua.GetTop(L)
+		*errp = nil
+
+		pushHelper(L, "pairs")
+		t.ref.push(L)
+		lua.PushBoolean(L, raw)
+		if status := lua.PCall(L, 2, 3, 0); status != lua.StatusOk {
+			*errp = popError(L, status)
+			lua.SetTop(L, top)
+			return
+		}
+
+		f, s, k := toValue(L, top+1), toValue(L, top+2), toValue(L, top+3)
+		lua.SetTop(L, top)
+
+		for {
+			L := t.ref.thread()
+			top := lua.GetTop(L)
+			if err := pushValues(L, f, s, k); err != nil {
+				*errp = err
+				return
+			}
+
+			if status := lua.PCall(L, 2, 2, 0); status != lua.StatusOk {
+				*errp = popError(L, status)
+				lua.SetTop(L, top)
+				return
+			}
+
+			var v Value
+			k, v = toValue(L, top+1), toValue(L, top+2)
+			lua.SetTop(L, top)
+
+			if k.IsNil() || !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Ipairs returns an iterator over the values of t at the keys 1, 2, ... up to the first nil value,
+// without calling metamethods.
+func (t *Table) Ipairs() iter.Seq2[int, Value] {
+	return func(yield func(int, Value) bool) {
+		for i := 1; ; i++ {
+			L := t.ref.thread()
+			t.ref.push(L)
+			lua.RawGetI(L, -1, i)
+			v := toValue(L, -1)
+			lua.Pop(L, 2)
+
+			if v.IsNil() || !yield(i, v) {
+				return
+			}
+		}
+	}
+}
+
+// Value returns the table as a Value.
+func (t *Table) Value() Value {
+	return Value{typ: lua.TTable, p: t.pointer(), ref: t.ref}
+}
+
+func (t *Table) pointer() uintptr {
+	L := t.ref.thread()
+	t.ref.push(L)
+	defer lua.Pop(L, 1)
+	return lua.ToPointer(L, -1)
+}
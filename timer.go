@@ -0,0 +1,260 @@
+package luajit
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// notScheduled returns the error of a function that can only suspend coroutines run by a scheduler.
+func notScheduled(name string) error {
+	return fmt.Errorf("luajit: %s must be called from a coroutine run by a scheduler", name)
+}
+
+// timer runs fire once the scheduler's clock reaches at, and then every interval if it's positive.
+type timer struct {
+	at        time.Time
+	seq       int // Orders timers due at the same time by creation
+	interval  time.Duration
+	fire      func()
+	cancelled bool
+}
+
+// timerHeap is a min-heap of timers ordered by due time.
+type timerHeap []*timer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+
+	return h[i].at.Before(h[j].at)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *timerHeap) Push(x any) {
+	*h = append(*h, x.(*timer))
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return t
+}
+
+// addTimer calls fire once d has elapsed, then every interval if it's positive.
+func (sc *Scheduler) addTimer(d, interval time.Duration, fire func()) *timer {
+	sc.seq++
+	t := &timer{at: sc.clock.Now().Add(d), seq: sc.seq, interval: interval, fire: fire}
+	heap.Push(&sc.timers, t)
+	return t
+}
+
+// nextTimer returns the due time of the earliest timer, dropping cancelled ones.
+func (sc *Scheduler) nextTimer() (time.Time, bool) {
+	for len(sc.timers) > 0 {
+		if t := sc.timers[0]; !t.cancelled {
+			return t.at, true
+		}
+
+		heap.Pop(&sc.timers)
+	}
+
+	return time.Time{}, false
+}
+
+// fireTimers runs the timers that are due.
+func (sc *Scheduler) fireTimers() {
+	now := sc.clock.Now()
+	for {
+		at, ok := sc.nextTimer()
+		if !ok || at.After(now) {
+			return
+		}
+
+		t := heap.Pop(&sc.timers).(*timer)
+		if t.interval > 0 {
+			// Timers late by more than their interval fire once rather than catching up.
+			t.at = now.Add(t.interval)
+			sc.seq++
+			t.seq = sc.seq
+			heap.Push(&sc.timers, t)
+		}
+
+		t.fire()
+	}
+}
+
+// openPrimitives sets the Lua functions of the scheduler as globals.
+func (sc *Scheduler) openPrimitives() error {
+	primitives := map[string]callable{
+		"sleep":  sc.luaSleep,
+		"spawn":  sc.luaSpawn,
+		"after":  sc.luaAfter,
+		"every":  sc.luaEvery,
+		"wait":   sc.luaWait,
+		"signal": sc.luaSignal,
+	}
+
+	L := sc.s.thread()
+	for name, c := range primitives {
+		pushCallable(L, c)
+		if err := setGlobal(L, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sc *Scheduler) luaSleep(L lua.State) (int, error) {
+	d, err := checkSeconds(L, 1, "sleep")
+	if err != nil {
+		return 0, err
+	}
+
+	_, t := taskOf(L)
+	if t == nil {
+		return 0, notScheduled("sleep")
+	}
+
+	t.waiting = true
+	sc.addTimer(d, 0, func() {
+		sc.resumeTask(t, func(L lua.State) (int, error) { return 0, nil })
+	})
+
+	return 0, errYield
+}
+
+func (sc *Scheduler) luaSpawn(L lua.State) (int, error) {
+	fn, err := checkFunction(L, 1, "spawn")
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([]any, max(lua.GetTop(L)-1, 0))
+	for i := range args {
+		args[i] = toValue(L, i+2)
+	}
+
+	return 0, sc.Spawn(fn, args...)
+}
+
+func (sc *Scheduler) luaAfter(L lua.State) (int, error) {
+	return sc.luaTimer(L, "after", false)
+}
+
+func (sc *Scheduler) luaEvery(L lua.State) (int, error) {
+	return sc.luaTimer(L, "every", true)
+}
+
+// luaTimer spawns a function after a delay, repeatedly if repeat is true.
+// It returns a function cancelling the timer.
+func (sc *Scheduler) luaTimer(L lua.State, name string, repeat bool) (int, error) {
+	d, err := checkSeconds(L, 1, name)
+	if err != nil {
+		return 0, err
+	}
+
+	fn, err := checkFunction(L, 2, name)
+	if err != nil {
+		return 0, err
+	}
+
+	var interval time.Duration
+	if repeat {
+		if d <= 0 {
+			return 0, &ArgError{Arg: 1, Func: name, Err: errors.New("interval must be positive")}
+		}
+
+		interval = d
+	}
+
+	tm := sc.addTimer(d, interval, func() {
+		if err := sc.Spawn(fn); err != nil {
+			sc.err = err
+		}
+	})
+
+	pushCallable(L, func(L lua.State) (int, error) {
+		tm.cancelled = true
+		return 0, nil
+	})
+
+	return 1, nil
+}
+
+func (sc *Scheduler) luaWait(L lua.State) (int, error) {
+	event := lua.ToString(L, 1)
+	if lua.Type(L, 1) != lua.TString {
+		err := &TypeError{Expected: "string", Got: lua.TypeNameOf(L, 1)}
+		return 0, &ArgError{Arg: 1, Func: "wait", Err: &UnmarshalError{Err: err}}
+	}
+
+	_, t := taskOf(L)
+	if t == nil {
+		return 0, notScheduled("wait")
+	}
+
+	if sc.closed() {
+		return 0, errSchedulerClosed
+	}
+
+	t.waiting = true
+	sc.events[event] = append(sc.events[event], t)
+	return 0, errYield
+}
+
+func (sc *Scheduler) luaSignal(L lua.State) (int, error) {
+	event := lua.ToString(L, 1)
+	if lua.Type(L, 1) != lua.TString {
+		err := &TypeError{Expected: "string", Got: lua.TypeNameOf(L, 1)}
+		return 0, &ArgError{Arg: 1, Func: "signal", Err: &UnmarshalError{Err: err}}
+	}
+
+	args := make([]any, max(lua.GetTop(L)-1, 0))
+	for i := range args {
+		args[i] = toValue(L, i+2)
+	}
+
+	lua.PushInteger(L, lua.Integer(sc.Signal(event, args...)))
+	return 1, nil
+}
+
+// checkSeconds returns the duration in seconds at idx.
+func checkSeconds(L lua.State, idx int, name string) (time.Duration, error) {
+	if lua.Type(L, idx) != lua.TNumber {
+		err := &TypeError{Expected: "number", Got: lua.TypeNameOf(L, idx)}
+		return 0, &ArgError{Arg: idx, Func: name, Err: &UnmarshalError{Err: err}}
+	}
+
+	sec := float64(lua.ToNumber(L, idx))
+	if math.IsNaN(sec) || sec < 0 {
+		sec = 0
+	}
+
+	return time.Duration(min(sec*float64(time.Second), math.MaxInt64)), nil
+}
+
+// checkFunction returns a reference to the function at idx.
+func checkFunction(L lua.State, idx int, name string) (*Function, error) {
+	if lua.Type(L, idx) != lua.TFunction {
+		err := &TypeError{Expected: "function", Got: lua.TypeNameOf(L, idx)}
+		return nil, &ArgError{Arg: idx, Func: name, Err: &UnmarshalError{Err: err}}
+	}
+
+	return &Function{ref: newReference(L, idx)}, nil
+}
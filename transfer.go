@@ -0,0 +1,270 @@
+package luajit
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// FunctionPolicy tells Transfer what to do with functions.
+type FunctionPolicy int
+
+const (
+	FunctionsFail FunctionPolicy = iota // Transfer fails
+	FunctionsSkip                       // Functions become nil, and the table fields holding them are left out
+	FunctionsDump                       // Lua functions without upvalues are reloaded from their bytecode, others fail
+)
+
+// TransferOption configures Transfer.
+type TransferOption func(*transfer)
+
+// WithFunctionPolicy sets what Transfer does with functions, FunctionsFail by default.
+func WithFunctionPolicy(p FunctionPolicy) TransferOption {
+	return func(tr *transfer) {
+		tr.funcs = p
+	}
+}
+
+// WithUserdata lets Transfer copy the userdata representing Go values, such as objects and channels.
+// Both states then refer to the same Go value.
+func WithUserdata() TransferOption {
+	return func(tr *transfer) {
+		tr.userdata = true
+	}
+}
+
+// Transfer pushes a deep copy of the value at fromIdx in from onto the stack of to.
+//
+// nil, booleans, numbers, strings and tables are copied, along with the metatables of tables.
+// Tables referenced more than once, including through cycles, are copied once and stay shared.
+// Functions are handled according to the function policy, and userdata representing Go values
+// are only copied with WithUserdata. Other values make Transfer fail, leaving both stacks unchanged.
+//
+// from and to may be unrelated states.
+func Transfer(from State, fromIdx int, to State, opts ...TransferOption) error {
+	tr := &transfer{from: from.thread(), to: to.thread(), seen: make(map[uintptr]int)}
+	for _, opt := range opts {
+		opt(tr)
+	}
+
+	if fromIdx < 0 && fromIdx > lua.RegistryIndex {
+		fromIdx = lua.GetTop(tr.from) + fromIdx + 1
+	}
+
+	fromTop, toTop := lua.GetTop(tr.from), lua.GetTop(tr.to)
+	lua.CheckStack(tr.to, 2)
+	lua.NewTable(tr.to)
+	tr.cache = toTop + 1
+
+	ok, err := tr.copy(fromIdx)
+	lua.SetTop(tr.from, fromTop)
+	if err != nil {
+		lua.SetTop(tr.to, toTop)
+		return err
+	}
+
+	if !ok {
+		lua.PushNil(tr.to)
+	}
+
+	lua.Remove(tr.to, tr.cache)
+	return nil
+}
+
+// transfer is the state of a call to Transfer.
+type transfer struct {
+	from, to lua.State
+	funcs    FunctionPolicy
+	userdata bool
+
+	seen  map[uintptr]int // Maps the objects copied to their position in the cache
+	cache int             // The index of the table in to holding the copies, to share them
+}
+
+var errTransferDepth = errors.New("luajit: value too deeply nested to transfer")
+
+// copy pushes a copy of the value at idx in from onto to, returning false if it's skipped.
+func (tr *transfer) copy(idx int) (bool, error) {
+	from, to := tr.from, tr.to
+	if lua.CheckStack(to, 4) == 0 || lua.CheckStack(from, 4) == 0 {
+		return false, errTransferDepth
+	}
+
+	t := lua.Type(from, idx)
+	switch t {
+	case lua.TNone, lua.TNil:
+		lua.PushNil(to)
+		return true, nil
+	case lua.TBoolean:
+		lua.PushBoolean(to, lua.ToBoolean(from, idx))
+		return true, nil
+	case lua.TNumber:
+		lua.PushNumber(to, lua.ToNumber(from, idx))
+		return true, nil
+	case lua.TString:
+		b := lua.ToBytes(from, idx)
+		lua.PushLString(to, string(b), len(b))
+		return true, nil
+	case lua.TLightUserdata:
+		lua.PushLightUserdata(to, lua.ToUserdata(from, idx))
+		return true, nil
+	}
+
+	p := lua.ToPointer(from, idx)
+	if id, ok := tr.seen[p]; ok {
+		lua.RawGetI(to, tr.cache, id)
+		return true, nil
+	}
+
+	var err error
+	switch t {
+	case lua.TTable:
+		return true, tr.copyTable(idx, p)
+	case lua.TFunction:
+		if tr.funcs == FunctionsSkip {
+			return false, nil
+		}
+
+		err = tr.copyFunction(idx)
+	case lua.TUserdata:
+		err = tr.copyUserdata(idx)
+	default:
+		err = fmt.Errorf("luajit: cannot transfer %s", lua.TypeNameOf(from, idx))
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	tr.remember(p)
+	return true, nil
+}
+
+// remember records the value at the top of to as the copy of the object at p.
+func (tr *transfer) remember(p uintptr) {
+	id := len(tr.seen) + 1
+	tr.seen[p] = id
+	lua.PushValue(tr.to, -1)
+	lua.RawSetI(tr.to, tr.cache, id)
+}
+
+// copyTable pushes a copy of the table at idx, whose address is p.
+func (tr *transfer) copyTable(idx int, p uintptr) error {
+	from, to := tr.from, tr.to
+	lua.NewTable(to)
+	tr.remember(p)
+	dst := lua.GetTop(to)
+
+	var err error
+	lua.ForEach(from, idx, func(k, v int) bool {
+		var ok bool
+		if ok, err = tr.copy(k); err != nil || !ok {
+			return err == nil
+		}
+
+		if ok, err = tr.copy(v); err != nil || !ok {
+			lua.Pop(to, 1)
+			return err == nil
+		}
+
+		lua.RawSet(to, dst)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if lua.GetMetatable(from, idx) {
+		ok, err := tr.copy(lua.GetTop(from))
+		lua.Pop(from, 1)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			lua.SetMetatable(to, dst)
+		}
+	}
+
+	return nil
+}
+
+// copyFunction pushes a copy of the function at idx, reloaded from its bytecode.
+func (tr *transfer) copyFunction(idx int) error {
+	if tr.funcs != FunctionsDump {
+		return errors.New("luajit: cannot transfer function")
+	}
+
+	b, err := dumpFunction(tr.from, idx)
+	if err != nil {
+		return fmt.Errorf("luajit: cannot transfer function: %w", err)
+	}
+
+	if status := lua.LoadBuffer(tr.to, b, "=transfer"); status != lua.StatusOk {
+		return popError(tr.to, status)
+	}
+
+	return nil
+}
+
+// dumpFunction returns the bytecode of the Lua function at idx, failing if it has upvalues.
+func dumpFunction(L lua.State, idx int) ([]byte, error) {
+	if idx < 0 && idx > lua.RegistryIndex {
+		idx = lua.GetTop(L) + idx + 1
+	}
+
+	lua.CheckStack(L, 3)
+	pushHelper(L, "dump")
+	lua.PushValue(L, idx)
+	if status := lua.PCall(L, 1, 2, 0); status != lua.StatusOk {
+		return nil, popError(L, status)
+	}
+
+	defer lua.Pop(L, 2)
+	if lua.IsNil(L, -2) {
+		return nil, errors.New(lua.ToString(L, -1))
+	}
+
+	return lua.ToBytes(L, -2), nil
+}
+
+// copyUserdata pushes a userdata representing the same Go value as the userdata at idx.
+func (tr *transfer) copyUserdata(idx int) error {
+	v, m := toHandleMeta(tr.from, idx)
+	if m == nil || !tr.userdata {
+		return errors.New("luajit: cannot transfer userdata")
+	}
+
+	pushHandleCopy(tr.to, v, m)
+	return nil
+}
+
+// toHandleMeta returns the Go value represented by the userdata at idx and its metatable,
+// or nil if it isn't such a userdata.
+func toHandleMeta(L lua.State, idx int) (any, *metatable) {
+	v := toHandle(L, idx)
+	if v == nil || !lua.GetMetatable(L, idx) {
+		return nil, nil
+	}
+
+	defer lua.Pop(L, 2)
+	lua.GetField(L, -1, "__name")
+	if m, ok := metatables.Load(lua.ToString(L, -1)); ok {
+		return v, m.(*metatable)
+	}
+
+	return nil, nil
+}
+
+// pushHandleCopy pushes a userdata representing v with the metatable m, possibly of another state.
+func pushHandleCopy(L lua.State, v any, m *metatable) {
+	// Objects are cached by pointer, so that pushing one twice gives the same userdata.
+	if m == objectMetaOf(v) {
+		pushObject(L, reflect.ValueOf(v))
+		return
+	}
+
+	pushHandle(L, v, m)
+}
@@ -0,0 +1,76 @@
+package luajit
+
+import (
+	"testing"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+func TestTransferCycles(t *testing.T) {
+	from, to := newTestState(t), newTestState(t)
+	if err := from.DoString(`
+		local shared = {n = 1}
+		root = setmetatable({a = shared, b = shared, list = {1, 2, 3}}, {tag = "mt"})
+		root.self = root
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := from.GetGlobal("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Marshal(from, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Transfer(from, -1, to); err != nil {
+		t.Fatal(err)
+	}
+	lua.Pop(from.thread(), 1)
+
+	if err := setGlobal(to.thread(), "root"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := to.DoString(`
+		assert(root.self == root, "cycle not kept")
+		assert(root.a == root.b, "shared table copied twice")
+		assert(#root.list == 3 and root.list[3] == 3, "sequence not copied")
+		assert(getmetatable(root).tag == "mt", "metatable not copied")
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferFunctions(t *testing.T) {
+	from, to := newTestState(t), newTestState(t)
+	if err := from.DoString(`local up = 1 root = {f = function() return up end}`); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := from.GetGlobal("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Marshal(from, v); err != nil {
+		t.Fatal(err)
+	}
+	defer lua.Pop(from.thread(), 1)
+
+	top := lua.GetTop(to.thread())
+	if err := Transfer(from, -1, to); err == nil {
+		t.Error("transferred a function with the default policy")
+	}
+	if lua.GetTop(to.thread()) != top {
+		t.Error("failed transfer left values on the stack")
+	}
+
+	if err := Transfer(from, -1, to, WithFunctionPolicy(FunctionsSkip)); err != nil {
+		t.Fatal(err)
+	}
+	lua.GetField(to.thread(), -1, "f")
+	if !lua.IsNil(to.thread(), -1) {
+		t.Error("function not skipped")
+	}
+}
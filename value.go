@@ -0,0 +1,289 @@
+package luajit
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+
+	"github.com/judah-caruso/go-luajit/lua"
+)
+
+// Value represents any Lua value.
+//
+// Nil, booleans, numbers, strings and light userdata are copied into the Value
+// and can be used from any goroutine.
+// Other values are references into the state they came from and keep the Lua value alive.
+//
+// The zero Value is nil.
+type Value struct {
+	typ lua.T
+	b   bool
+	n   float64
+	s   string
+	p   uintptr
+	ref *reference
+}
+
+// Type returns the Lua type of the value.
+func (v Value) Type() lua.T {
+	if v.typ == lua.TNone {
+		return lua.TNil
+	}
+
+	return v.typ
+}
+
+// TypeName returns the name of the Lua type of the value.
+func (v Value) TypeName() string {
+	return typeNames[v.Type()]
+}
+
+// IsNil returns if the value is nil.
+func (v Value) IsNil() bool {
+	return v.Type() == lua.TNil
+}
+
+// Bool returns false if the value is nil or false, true otherwise.
+func (v Value) Bool() bool {
+	switch v.Type() {
+	case lua.TNil:
+		return false
+	case lua.TBoolean:
+		return v.b
+	default:
+		return true
+	}
+}
+
+// Number returns the value if it is a number, 0 otherwise.
+func (v Value) Number() float64 {
+	return v.n
+}
+
+// Int returns the value truncated to an int if it is a number, 0 otherwise.
+func (v Value) Int() int {
+	return int(v.n)
+}
+
+// String returns the value if it is a string.
+// Otherwise it returns a description of the value, similar to Lua's tostring.
+func (v Value) String() string {
+	switch v.Type() {
+	case lua.TNil:
+		return "nil"
+	case lua.TBoolean:
+		return strconv.FormatBool(v.b)
+	case lua.TNumber:
+		return formatNumber(v.n)
+	case lua.TString:
+		return v.s
+	default:
+		return fmt.Sprintf("%s: %#x", v.TypeName(), v.p)
+	}
+}
+
+// Table returns the value if it is a table, nil otherwise.
+func (v Value) Table() *Table {
+	if v.Type() != lua.TTable {
+		return nil
+	}
+
+	return &Table{ref: v.ref}
+}
+
+// Function returns the value if it is a function, nil otherwise.
+func (v Value) Function() *Function {
+	if v.Type() != lua.TFunction {
+		return nil
+	}
+
+	return &Function{ref: v.ref}
+}
+
+// Coroutine returns the value if it is a coroutine, nil otherwise.
+func (v Value) Coroutine() *Coroutine {
+	if v.Type() != lua.TThread {
+		return nil
+	}
+
+	// Closing the coroutine must not release the value.
+	L := v.ref.thread()
+	v.ref.push(L)
+	defer lua.Pop(L, 1)
+	return &Coroutine{ref: newReference(L, -1), co: lua.ToThread(L, -1)}
+}
+
+// Interface converts the value to a Go value.
+//
+// Nil, booleans, numbers and strings are converted to nil, bool, float64 and string.
+// Tables are converted to []any if they are sequences, map[string]any if all of their keys are strings,
+// and map[any]any otherwise.
+// Functions are converted to *Function and other values are returned as Value.
+func (v Value) Interface() (any, error) {
+	if v.ref == nil {
+		var out any
+		switch v.Type() {
+		case lua.TBoolean:
+			out = v.b
+		case lua.TNumber:
+			out = v.n
+		case lua.TString:
+			out = v.s
+		case lua.TLightUserdata:
+			out = v
+		}
+
+		return out, nil
+	}
+
+	L := v.ref.thread()
+	v.ref.push(L)
+	defer lua.Pop(L, 1)
+
+	var out any
+	err := decodeAny(L, -1, &out)
+	return out, err
+}
+
+// push pushes the value onto the stack of L.
+func (v Value) push(L lua.State) error {
+	switch v.Type() {
+	case lua.TNil:
+		lua.PushNil(L)
+	case lua.TBoolean:
+		lua.PushBoolean(L, v.b)
+	case lua.TNumber:
+		lua.PushNumber(L, lua.Number(v.n))
+	case lua.TString:
+		lua.PushLString(L, v.s, len(v.s))
+	case lua.TLightUserdata:
+		lua.PushLightUserdata(L, v.p)
+	default:
+		return v.ref.pushTo(L)
+	}
+
+	return nil
+}
+
+// toValue returns the value at idx.
+func toValue(L lua.State, idx int) Value {
+	t := lua.Type(L, idx)
+	switch t {
+	case lua.TNone, lua.TNil:
+		return Value{}
+	case lua.TBoolean:
+		return Value{typ: t, b: lua.ToBoolean(L, idx)}
+	case lua.TNumber:
+		return Value{typ: t, n: float64(lua.ToNumber(L, idx))}
+	case lua.TString:
+		return Value{typ: t, s: lua.ToString(L, idx)}
+	case lua.TLightUserdata:
+		return Value{typ: t, p: lua.ToUserdata(L, idx)}
+	default:
+		return Value{typ: t, p: lua.ToPointer(L, idx), ref: newReference(L, idx)}
+	}
+}
+
+// popValue pops the value at the top of the stack.
+func popValue(L lua.State) Value {
+	v := toValue(L, -1)
+	lua.Pop(L, 1)
+	return v
+}
+
+var typeNames = map[lua.T]string{
+	lua.TNil:           "nil",
+	lua.TBoolean:       "boolean",
+	lua.TLightUserdata: "userdata",
+	lua.TNumber:        "number",
+	lua.TString:        "string",
+	lua.TTable:         "table",
+	lua.TFunction:      "function",
+	lua.TUserdata:      "userdata",
+	lua.TThread:        "thread",
+}
+
+// formatNumber formats n the same way Lua does.
+func formatNumber(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	}
+
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// reference keeps a Lua value alive in the registry while Go holds onto it.
+type reference struct {
+	d  *stateData
+	id int
+	co lua.State // The thread referenced, if it's in states
+}
+
+// newReference creates a reference to the value at idx.
+func newReference(L lua.State, idx int) *reference {
+	lua.PushValue(L, idx)
+	return popReference(L)
+}
+
+// popReference pops the value at the top of the stack and creates a reference to it.
+func popReference(L lua.State) *reference {
+	r := &reference{d: State(L).data(), id: lua.Ref(L, lua.RegistryIndex)}
+	runtime.SetFinalizer(r, (*reference).finalize)
+	return r
+}
+
+// finalize queues the reference to be released by its state, as Lua cannot be called from a finalizer.
+func (r *reference) finalize() {
+	r.forget()
+
+	r.d.mu.Lock()
+	r.d.dead = append(r.d.dead, r.id)
+	r.d.mu.Unlock()
+}
+
+// release immediately releases the reference.
+func (r *reference) release() {
+	r.forget()
+	runtime.SetFinalizer(r, nil)
+	lua.Unref(r.thread(), lua.RegistryIndex, r.id)
+	r.id = lua.RefNil
+}
+
+// forget removes the thread referenced from states, before the reference is released.
+func (r *reference) forget() {
+	if r.co != 0 {
+		states.Lock()
+		delete(states.m, r.co)
+		states.Unlock()
+		r.co = 0
+	}
+}
+
+// thread returns the thread to use when operating on the referenced value.
+//
+// The owner of the state is checked first, as by State.data.
+func (r *reference) thread() lua.State {
+	r.d.check()
+	return r.d.current
+}
+
+// push pushes the referenced value onto the stack of L.
+func (r *reference) push(L lua.State) {
+	lua.RawGetI(L, lua.RegistryIndex, r.id)
+}
+
+// pushTo pushes the referenced value onto the stack of L, failing if L belongs to another state.
+func (r *reference) pushTo(L lua.State) error {
+	if State(L).data() != r.d {
+		return fmt.Errorf("luajit: value belongs to a different state")
+	}
+
+	r.push(L)
+	return nil
+}
@@ -0,0 +1,270 @@
+package luajit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrWorkersClosed is returned by the futures of jobs submitted once Workers is closed.
+var ErrWorkersClosed = errors.New("luajit: workers closed")
+
+// WorkersOption configures Workers.
+type WorkersOption func(*Workers)
+
+// WithQueueSize sets the number of jobs that can wait for a worker, 0 by default.
+// Submit blocks while the queue is full.
+func WithQueueSize(n int) WorkersOption {
+	return func(w *Workers) {
+		w.queueSize = n
+	}
+}
+
+// WithJobTimeout interrupts the jobs running for longer than d, as by State.CallContext.
+func WithJobTimeout(d time.Duration) WorkersOption {
+	return func(w *Workers) {
+		w.timeout = d
+	}
+}
+
+// WithWorkerSetup calls fn on the state of each worker before running the init script,
+// typically to register Go functions.
+func WithWorkerSetup(fn func(s State) error) WorkersOption {
+	return func(w *Workers) {
+		w.setup = fn
+	}
+}
+
+// Workers runs Lua functions on a fixed number of goroutines, each owning its own state.
+//
+// Jobs are run in the order they're submitted by the first idle worker,
+// so they must not rely on the globals set by the previous ones.
+//
+// Workers is safe for concurrent use.
+type Workers struct {
+	queueSize int
+	timeout   time.Duration
+	setup     func(s State) error
+
+	mu     sync.RWMutex // Held for writing to close jobs
+	jobs   chan *job
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// job is a call submitted to Workers.
+type job struct {
+	ctx    context.Context
+	fn     string
+	args   []any
+	future *Future
+}
+
+// Future is the result of a job submitted to Workers.
+type Future struct {
+	done    chan struct{}
+	results []any
+	err     error
+}
+
+// Done returns a channel that's closed once the job is done.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits until the job is done or ctx is done, and returns its results.
+func (f *Future) Wait(ctx context.Context) ([]any, error) {
+	select {
+	case <-f.done:
+		return f.results, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Result waits until the job is done and returns its results.
+func (f *Future) Result() ([]any, error) {
+	<-f.done
+	return f.results, f.err
+}
+
+// complete sets the results of the job.
+func (f *Future) complete(results []any, err error) {
+	f.results, f.err = results, err
+	close(f.done)
+}
+
+// NewWorkers starts n workers, each with a new state with the standard libraries open, in which init is run.
+//
+// init typically defines the functions the jobs call. If it fails in any worker, the workers are closed
+// and its error is returned.
+func NewWorkers(n int, init string, opts ...WorkersOption) (*Workers, error) {
+	w := &Workers{}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	w.jobs = make(chan *job, w.queueSize)
+
+	n = max(n, 1)
+	ready := make(chan error, n)
+	for range n {
+		w.wg.Add(1)
+		go w.work(init, ready)
+	}
+
+	var err error
+	for range n {
+		err = errors.Join(err, <-ready)
+	}
+
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Submit queues a call to the global function fnName with args, converted as by State.SetGlobal.
+//
+// The results are converted as by Value.Interface, and must not contain functions or other values
+// that only exist in the state of the worker.
+// Submit blocks while the queue is full.
+func (w *Workers) Submit(fnName string, args ...any) *Future {
+	return w.SubmitContext(context.Background(), fnName, args...)
+}
+
+// SubmitContext is like Submit, but stops waiting for room in the queue once ctx is done,
+// and interrupts the call as by State.CallContext.
+func (w *Workers) SubmitContext(ctx context.Context, fnName string, args ...any) *Future {
+	f := &Future{done: make(chan struct{})}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		f.complete(nil, ErrWorkersClosed)
+		return f
+	}
+
+	select {
+	case w.jobs <- &job{ctx: ctx, fn: fnName, args: args, future: f}:
+	case <-ctx.Done():
+		f.complete(nil, ctx.Err())
+	}
+
+	return f
+}
+
+// Close stops accepting jobs, waits for the queued ones to be done, and closes the states of the workers.
+func (w *Workers) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.jobs)
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+}
+
+// work runs jobs on a new state until jobs is closed.
+func (w *Workers) work(init string, ready chan<- error) {
+	defer w.wg.Done()
+
+	s := NewState()
+	defer s.Close()
+
+	s.OpenLibs()
+	err := func() error {
+		if w.setup != nil {
+			if err := w.setup(s); err != nil {
+				return err
+			}
+		}
+
+		return s.DoString(init)
+	}()
+
+	ready <- err
+	if err != nil {
+		return
+	}
+
+	for j := range w.jobs {
+		j.future.complete(w.run(s, j))
+	}
+}
+
+// run calls the function of j.
+func (w *Workers) run(s State, j *job) ([]any, error) {
+	ctx := j.ctx
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+
+	v, err := s.GetGlobal(j.fn)
+	if err != nil {
+		return nil, err
+	}
+
+	fn := v.Function()
+	if fn == nil {
+		return nil, fmt.Errorf("luajit: no function named %q", j.fn)
+	}
+
+	values, err := s.CallContext(ctx, fn, j.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]any, len(values))
+	for i, v := range values {
+		if results[i], err = v.Interface(); err != nil {
+			return nil, err
+		}
+
+		if err := checkDetached(results[i]); err != nil {
+			return nil, fmt.Errorf("luajit: result %d of %s: %w", i+1, j.fn, err)
+		}
+	}
+
+	return results, nil
+}
+
+// checkDetached fails if v holds values that refer to a state, as converted by Value.Interface.
+func checkDetached(v any) error {
+	switch v := v.(type) {
+	case *Function, Value:
+		return fmt.Errorf("cannot return %T from a worker", v)
+	case []any:
+		for _, e := range v {
+			if err := checkDetached(e); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, e := range v {
+			if err := checkDetached(e); err != nil {
+				return err
+			}
+		}
+	case map[any]any:
+		for k, e := range v {
+			if err := checkDetached(k); err != nil {
+				return err
+			}
+
+			if err := checkDetached(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}